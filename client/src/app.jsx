@@ -63,6 +63,7 @@ export default class App extends React.Component {
             <HighlightLink href={base+"/-/mem"} className="nav-item">Memory</HighlightLink>
             <HighlightLink href={base+"/-/liveness"} className="nav-item">Liveness Probe</HighlightLink>
             <HighlightLink href={base+"/-/readiness"} className="nav-item">Readiness Probe</HighlightLink>
+            <HighlightLink href={base+"/-/startup"} className="nav-item">Startup Probe</HighlightLink>
             <HighlightLink href={base+"/-/dns"} className="nav-item">DNS Query</HighlightLink>
             <HighlightLink href={base+"/-/keygen"} className="nav-item">KeyGen Workload</HighlightLink>
             <HighlightLink href={base+"/-/memq"} className="nav-item">MemQ Server</HighlightLink>
@@ -75,6 +76,7 @@ export default class App extends React.Component {
               <Location path={base+"/-/mem"} apiPath={base+"/mem/api"} handler={Mem}/>
               <Location path={base+"/-/liveness"} serverPath={base+"/healthy"} handler={Probe}/>
               <Location path={base+"/-/readiness"} serverPath={base+"/ready"} handler={Probe}/>
+              <Location path={base+"/-/startup"} serverPath={base+"/started"} handler={Probe}/>
               <Location path={base+"/-/dns"} serverPath={base+"/dns"} handler={Dns}/>
               <Location path={base+"/-/keygen"} serverPath={base+"/keygen"} handler={KeyGen}/>
               <Location path={base+"/-/memq"} serverPath={base+"/memq"} handler={MemQ}/>
@@ -10,6 +10,32 @@ const schema = {
       "title": "Enabled?",
       "type": "boolean"
     },
+    "kind": {
+      "title": "Kind of work for each item",
+      "type": "string",
+      "enum": ["keygen", "sha256", "memory", "disk"]
+    },
+    "itemSizeMB": {
+      "title": "Size in MiB of the buffer or file for the sha256, memory and disk kinds.",
+      "type": "integer"
+    },
+    "algorithm": {
+      "title": "Key algorithm.",
+      "type": "string",
+      "enum": ["rsa-2048", "rsa-3072", "rsa-4096", "ecdsa-p256", "ecdsa-p384", "ecdsa-p521", "ed25519"]
+    },
+    "outputDir": {
+      "title": "Directory to write generated keys to. Empty doesn't write keys.",
+      "type": "string"
+    },
+    "outputPrivateKeys": {
+      "title": "Write private keys too?",
+      "type": "boolean"
+    },
+    "historySize": {
+      "title": "Lines of workload output to keep.",
+      "type": "integer"
+    },
     "exitOnComplete": {
       "title": "Exit server on completion?",
       "type": "boolean"
@@ -22,21 +48,82 @@ const schema = {
       "title": "Number of keys to generate. 0 is infinite.",
       "type": "integer"
     },
+    "completions": {
+      "title": "Number of pods in an Indexed Job to split keys between. 0 is no split.",
+      "type": "integer"
+    },
+    "completionIndex": {
+      "title": "Index of this pod in an Indexed Job.",
+      "type": "integer"
+    },
+    "parallelism": {
+      "title": "Number of concurrent workers.",
+      "type": "integer"
+    },
+    "targetCPU": {
+      "title": "Target CPU percentage per worker. 0 is unthrottled.",
+      "type": "integer"
+    },
+    "targetCPUOfQuota": {
+      "title": "Target CPU is a percentage of the container CPU limit?",
+      "type": "boolean"
+    },
+    "rate": {
+      "title": "Keys to generate per rate unit. 0 is unlimited.",
+      "type": "number"
+    },
+    "rateUnit": {
+      "title": "Rate unit",
+      "type": "string",
+      "enum": ["second", "minute"]
+    },
     "timeToRun": {
       "title": "Time to run, in seconds. 0 is infinite.",
       "type": "integer"
     },
+    "mode": {
+      "title": "Mode. Empty picks memq if the MemQ server and queue are set, otherwise local.",
+      "type": "string",
+      "enum": ["", "local", "memq", "producer"]
+    },
     "memQServer": {
       "title": "Base URL of the MemQ server to draw from. Can be http://localhost:8080/memq/server.",
       "type": "string"
     },
     "memQQueue": {
-      "title": "The Queue to pull work items from.",
+      "title": "The Queue to pull work items from. Can be a list with weights, such as high=3,low.",
+      "type": "string"
+    },
+    "memQStrategy": {
+      "title": "How to pick between multiple queues.",
+      "type": "string",
+      "enum": ["priority", "weighted"]
+    },
+    "memQErrorBudget": {
+      "title": "Number of MemQ errors before giving up. 0 is unlimited.",
+      "type": "integer"
+    },
+    "memQErrorExitCode": {
+      "title": "Exit code when giving up.",
+      "type": "integer"
+    },
+    "memQResultsQueue": {
+      "title": "The Queue to publish work item results to. Optional.",
       "type": "string"
     }
   }
 };
 
+// The status fields that the server leaves out when they don't apply.  They
+// are reset on each update so that old values don't linger.
+const optionalStatus = {
+  error: null,
+  remainingSeconds: null,
+  items: null,
+  cpu: null,
+  rate: null
+};
+
 const uiSchema = {
   enable: {
     classNames: "foo"
@@ -49,6 +136,8 @@ export default class KeyGen extends React.Component {
     this.state = {
       config: {
         enable: false,
+        algorithm: "rsa-4096",
+        parallelism: 1,
         numToGen: 0,
         timeToRun: 0,
         exitOnComplete: false,
@@ -61,23 +150,42 @@ export default class KeyGen extends React.Component {
     this.handleSubmit = this.handleSubmit.bind(this);
   }
 
+  // loadState refreshes the status of the workload.  The output comes from
+  // the stream after the initial load.  The config is only replaced when it
+  // changes on the server so that edits in progress aren't lost.
   loadState(initial) {
     fetch(this.props.serverPath)
     .then(fetchError)
     .then(response => response.json())
     .then(response => {
-      if(initial) {
+      let serverConfig = JSON.stringify(response.config);
+      if (initial) {
         this.setState(response);
+        let h = response.history;
+        this.openStream(h.length > 0 ? h[h.length - 1].id : -1);
       } else {
-        this.setState(state => {
-          state.history = response.history
-          return state
-        })
+        let changed = serverConfig !== this.serverConfig;
+        this.setState(state => Object.assign({}, optionalStatus, response, {
+          config: changed ? response.config : state.config,
+          history: state.history
+        }));
       }
+      this.serverConfig = serverConfig;
     })
     .catch(err => this.context.reportConnError());
   }
 
+  // openStream follows the workload output as it happens.  The browser
+  // reconnects on its own, picking up from the last entry it saw.
+  openStream(since) {
+    this.stream = new EventSource(this.props.serverPath + "/stream?since=" + since);
+    this.stream.onmessage = e => this.setState(state => {
+      let size = state.config.historySize || 20;
+      let history = state.history.concat([{id: Number(e.lastEventId), data: e.data}]);
+      return {history: history.slice(-size)};
+    });
+  }
+
   componentDidMount() {
     this.loadState(true)
     this.timer = setInterval(this.loadState.bind(this, false), 1000);
@@ -85,6 +193,9 @@ export default class KeyGen extends React.Component {
 
   componentWillUnmount() {
     clearInterval(this.timer);
+    if (this.stream) {
+      this.stream.close();
+    }
   }
 
   handleSubmit(event) {
@@ -95,18 +206,15 @@ export default class KeyGen extends React.Component {
     })
     .then(fetchError)
     .then(response => response.json())
-    .then(response => this.setState(previousState => {
-      previousState = response
-      return previousState
-    }))
+    .then(response => {
+      this.serverConfig = JSON.stringify(response.config);
+      this.setState(Object.assign({}, optionalStatus, response));
+    })
     .catch(err => this.context.reportConnError());
   }
 
   handleChange({formData}) {
-    this.setState(state => {
-      state.config = formData;
-      return state
-    })
+    this.setState({config: formData});
   }
 
   render () {
@@ -120,15 +228,35 @@ export default class KeyGen extends React.Component {
       history = (<pre>{historyItems}</pre>)
     }
 
+    let status = null
+    if (this.state.state) {
+      let parts = [
+        this.state.state,
+        this.state.generated + " done",
+        this.state.errors + " errors",
+        Math.round(this.state.elapsedSeconds) + "s elapsed"
+      ];
+      if (this.state.remainingSeconds != null) {
+        parts.push("about " + Math.round(this.state.remainingSeconds) + "s left");
+      }
+      if (this.state.rate) {
+        parts.push(this.state.rate.achieved.toFixed(1) + " per " + this.state.rate.unit);
+      }
+      if (this.state.error) {
+        parts.push("error: " + this.state.error);
+      }
+      status = (<p>Status: {parts.join(", ")}</p>)
+    }
+
     return (
       <div>
         <div className="panel panel-default">
           <div className="panel-heading">KeyGen Synthetic Workload</div>
           <div className="panel-body">
-            <div>This controls a synthetic workload on the server: creating 4096
-                 bit RSA key pairs.  These parameters control how many to create
-                 and, optionally, cause the server to exit with a specific exit
-                 code.
+            <div>This controls a synthetic workload on the server: creating key
+                 pairs (4096 bit RSA by default).  These parameters control how
+                 many to create and, optionally, cause the server to exit with a
+                 specific exit code.
             </div>
             <Form
               schema={schema}
@@ -144,6 +272,7 @@ export default class KeyGen extends React.Component {
             </Form>
           </div>
         </div>
+        {status}
         {history}
       </div>
     )
@@ -10,12 +10,19 @@ See \`pkg/memq/types.go\` for the data structures returned.
 
 | Method | Url | Desc
 | --- | --- | ---
-| \`GET\` | \`/stats\` | Get stats on all queues
+| \`GET\` | \`/stats\` | Get stats on all queues.  Add \`?queue=<name>\` to limit to specific queues.
 | \`PUT\` | \`/queues/:queue\` | Create a queue
 | \`DELETE\` | \`/queue/:queue\` | Delete a queue
 | \`POST\` | \`/queue/:queue/drain\` | Discard all items in queue
 | \`POST\` | \`/queue/:queue/enqueue\` | Add item to queue.  Body is plain text. Response is message object.
 | \`POST\` | \`/queue/:queue/dequeue\` | Grab an item off the queue and return it. Returns a 204 "No Content" if queue is empty.
+| \`GET\` | \`/queues/:queue/messages\` | List the messages currently in the queue, oldest first.
+| \`DELETE\` | \`/queues/:queue/messages/:id\` | Delete a single message from the queue.
+| \`POST\` | \`/queues/:queue/messages/:id/requeue\` | Move a single message to the tail of the queue.
+| \`POST\` | \`/queues/:queue/move\` | Move messages to another queue.  Body is JSON \`{"destination": "<queue>", "ids": [...]}\`.  If \`ids\` is empty, all messages are moved.
+| \`GET\` | \`/queues/:queue/subscriptions\` | List webhook subscriptions for the queue.
+| \`POST\` | \`/queues/:queue/subscriptions\` | Push messages to a webhook.  Body is JSON \`{"url": "http://...", "concurrency": 1}\`.  Each message is POSTed as JSON; 2xx responses are success and anything else is retried with backoff.
+| \`DELETE\` | \`/queues/:queue/subscriptions/:id\` | Remove a webhook subscription.
 `
 
 export default class MemQ extends React.Component {
@@ -68,6 +75,9 @@ export default class MemQ extends React.Component {
             <td>{q.enqueued}</td>
             <td>{q.dequeued}</td>
             <td>{q.drained}</td>
+            <td>{q.enqueueRate1m.toFixed(2)}</td>
+            <td>{q.dequeueRate1m.toFixed(2)}</td>
+            <td>{q.oldestMessageAgeSeconds.toFixed(1)}s</td>
           </tr>
         )
       }
@@ -80,6 +90,9 @@ export default class MemQ extends React.Component {
               <th>Enqueued</th>
               <th>Dequeued</th>
               <th>Drained</th>
+              <th>Enq/s (1m)</th>
+              <th>Deq/s (1m)</th>
+              <th>Oldest</th>
             </tr>
           </thead>
           <tbody>
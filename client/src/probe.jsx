@@ -7,6 +7,19 @@ export default class Probe extends React.Component {
     this.state = {
       probePath: '',
       failNext: 0,
+      delayMS: 0,
+      delayJitterMS: 0,
+      delayNext: 0,
+      slowStartSeconds: 0,
+      failProbability: 0,
+      flapSucceed: 0,
+      flapFail: 0,
+      failAfterSeconds: 0,
+      failForSeconds: 0,
+      sequence: null,
+      failCode: 500,
+      failHeaders: null,
+      failBody: "",
       history: []
     };
   }
@@ -30,9 +43,11 @@ export default class Probe extends React.Component {
 
   configure(e, n) {
     e.preventDefault();
-    let payload = JSON.stringify({
-      failNext: n
-    });
+    // Keep the rest of the config as it is.
+    let config = Object.assign({}, this.state, {failNext: n});
+    delete config.probePath;
+    delete config.history;
+    let payload = JSON.stringify(config);
     fetch(this.props.serverPath+"/api", {
       method: "PUT",
       body: payload
@@ -54,6 +69,57 @@ export default class Probe extends React.Component {
       probeDesc = <span> Probe will permanently fail </span>;
     }
 
+    let failDesc = null
+    if ((this.state.failCode && this.state.failCode != 500) ||
+        (this.state.failHeaders && this.state.failHeaders.length > 0) ||
+        this.state.failBody) {
+      let desc = "Failures respond with " + (this.state.failCode || 500)
+      if (this.state.failHeaders && this.state.failHeaders.length > 0) {
+        desc += ", headers " + this.state.failHeaders.join("; ")
+      }
+      if (this.state.failBody) {
+        desc += ", body \"" + this.state.failBody + "\""
+      }
+      failDesc = <span> {desc}</span>;
+    }
+
+    let delayDesc = null
+    if (this.state.delayMS > 0 || this.state.delayJitterMS > 0) {
+      let delay = this.state.delayMS + "ms"
+      if (this.state.delayJitterMS > 0) {
+        delay += " plus up to " + this.state.delayJitterMS + "ms"
+      }
+      if (this.state.delayNext > 0) {
+        delayDesc = <span> Probe will be delayed {delay} for next {this.state.delayNext} calls</span>;
+      } else {
+        delayDesc = <span> Probe will be delayed {delay}</span>;
+      }
+    }
+
+    let patterns = [];
+    if (this.state.sequence && this.state.sequence.length > 0) {
+      patterns.push("respond with " + this.state.sequence.join(", ") + " in turn");
+    } else {
+      if (this.state.failForSeconds > 0) {
+        patterns.push("fail for " + this.state.failForSeconds + "s starting " + this.state.failAfterSeconds + "s after start");
+      }
+      if (this.state.flapFail > 0) {
+        patterns.push("alternate " + this.state.flapSucceed + " successes and " + this.state.flapFail + " failures");
+      }
+      if (this.state.failProbability > 0) {
+        patterns.push("fail " + (this.state.failProbability * 100) + "% of the time");
+      }
+    }
+    let slowStartDesc = null
+    if (this.state.slowStartSeconds > 0) {
+      slowStartDesc = <span> Probe will fail until {this.state.slowStartSeconds}s after start</span>;
+    }
+
+    let patternDesc = null
+    if (patterns.length > 0) {
+      patternDesc = <span> Otherwise probe will {patterns.join(" and ")}</span>;
+    }
+
     let history = <p> No recorded probe history </p>
     if (this.state.history.length > 0) {
       let rows = [];
@@ -64,6 +130,7 @@ export default class Probe extends React.Component {
             <td>{h.when}</td>
             <td>{h.relWhen}</td>
             <td>{h.code}</td>
+            <td>{h.delayMS}ms</td>
           </tr>
         )
       }
@@ -71,7 +138,7 @@ export default class Probe extends React.Component {
         <table className="table table-condensed table-bordered">
           <thead>
             <tr>
-              <th>ID</th><th colSpan="2">When</th><th>Status</th>
+              <th>ID</th><th colSpan="2">When</th><th>Status</th><th>Delay</th>
             </tr>
           </thead>
           <tbody>
@@ -85,6 +152,10 @@ export default class Probe extends React.Component {
       <div>
         <p>Probe is being served on <a href={this.props.serverPath}>{this.props.serverPath}</a></p>
         <p>{probeDesc}<br/>
+           {slowStartDesc}{slowStartDesc && <br/>}
+           {patternDesc}{patternDesc && <br/>}
+           {failDesc}{failDesc && <br/>}
+           {delayDesc}{delayDesc && <br/>}
            <span className="small">
              <a className="failn" onClick={e => this.configure(e, 0)} href="#">Succeed</a> | { " " }
              <a className="failn" onClick={e => this.configure(e, -1)} href="#">Fail</a> | { " " }
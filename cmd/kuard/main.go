@@ -19,6 +19,7 @@ package main
 import (
 	"encoding/json"
 	"log"
+	"os"
 	"strings"
 
 	"github.com/spf13/pflag"
@@ -29,6 +30,10 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 2 && os.Args[1] == "memq" && os.Args[2] == "bench" {
+		os.Exit(memqBenchMain(os.Args[3:]))
+	}
+
 	app := app.NewApp()
 
 	v := viper.GetViper()
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/kubernetes-up-and-running/kuard/pkg/memq/bench"
+)
+
+// memqBenchMain implements `kuard memq bench`.  It returns the process exit
+// code.
+func memqBenchMain(args []string) int {
+	fs := pflag.NewFlagSet("memq bench", pflag.ContinueOnError)
+	c := memqbench.Config{}
+	fs.StringVar(&c.Server, "server", "http://localhost:8080/memq/server", "The MemQ server to benchmark")
+	fs.StringVar(&c.Queue, "queue", "", "The queue to use. Defaults to a new queue that is deleted after the run")
+	fs.IntVar(&c.Producers, "producers", 1, "The number of concurrent producers")
+	fs.IntVar(&c.Consumers, "consumers", 1, "The number of concurrent consumers")
+	fs.DurationVar(&c.Duration, "duration", 10*time.Second, "How long to run for. Set to 0 for infinite")
+	fs.Int64Var(&c.Messages, "messages", 0, "The total number of messages to produce and consume. Set to 0 for infinite")
+	fs.IntVar(&c.MessageSize, "message-size", 64, "The size of each message body in bytes")
+	fs.BoolVar(&c.CreateQueue, "create-queue", true, "Create the named --queue if it doesn't exist")
+	output := fs.String("output", "text", "Output format. One of 'text' or 'json'")
+
+	if err := fs.Parse(args); err != nil {
+		if err == pflag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if *output != "text" && *output != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", *output)
+		return 2
+	}
+
+	// Each worker holds its own connection so make sure they are all reused.
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.MaxIdleConnsPerHost = c.Producers + c.Consumers
+	}
+
+	r, err := memqbench.Run(context.Background(), c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running benchmark: %v\n", err)
+		return 1
+	}
+
+	if *output == "json" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error encoding result: %v\n", err)
+			return 1
+		}
+		fmt.Println(string(b))
+	} else {
+		r.WriteText(os.Stdout)
+	}
+	return 0
+}
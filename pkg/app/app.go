@@ -16,6 +16,7 @@ limitations under the License.
 package app
 
 import (
+	"context"
 	"html/template"
 	"log"
 	"net"
@@ -25,6 +26,8 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kubernetes-up-and-running/kuard/pkg/debugprobe"
 	"github.com/kubernetes-up-and-running/kuard/pkg/dnsapi"
@@ -41,6 +44,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// shutdownTimeout is how long in-flight requests get to finish when the process
+// is exiting.
+const shutdownTimeout = 10 * time.Second
+
 func init() {
 	prometheus.MustRegister(requestDuration)
 }
@@ -80,15 +87,19 @@ type App struct {
 	c  Config
 	tg *htmlutils.TemplateGroup
 
-	m     *memory.MemoryAPI
-	live  *debugprobe.Probe
-	ready *debugprobe.Probe
-	env   *env.Env
-	dns   *dnsapi.DNSAPI
-	kg    *keygen.KeyGen
-	mq    *memqserver.Server
+	m       *memory.MemoryAPI
+	live    *debugprobe.Probe
+	ready   *debugprobe.Probe
+	started *debugprobe.Probe
+	env     *env.Env
+	dns     *dnsapi.DNSAPI
+	kg      *keygen.KeyGen
+	mq      *memqserver.Server
 
 	r *httprouter.Router
+
+	mu      sync.Mutex
+	servers []*http.Server
 }
 
 func (k *App) getPageContext(r *http.Request, urlBase string) *pageContext {
@@ -140,16 +151,59 @@ func (k *App) Run() {
 	certFile := filepath.Join(k.c.TLSDir, "kuard.crt")
 	keyFile := filepath.Join(k.c.TLSDir, "kuard.key")
 	if fileExists(certFile) && fileExists(keyFile) {
+		s := k.newServer(k.c.TLSAddr, r)
 		go func() {
 			log.Printf("Serving HTTPS on %v", k.c.TLSAddr)
-			log.Fatal(http.ListenAndServeTLS(k.c.TLSAddr, certFile, keyFile, r))
+			err := s.ListenAndServeTLS(certFile, keyFile)
+			if err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
 		}()
 	} else {
 		log.Printf("Could not find certificates to serve TLS")
 	}
 
+	k.mq.Run()
+
+	s := k.newServer(k.c.ServeAddr, r)
 	log.Printf("Serving on HTTP on %v", k.c.ServeAddr)
-	log.Fatal(http.ListenAndServe(k.c.ServeAddr, r))
+	err := s.ListenAndServe()
+	if err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+
+	// We are shutting down.  Exit will end the process once in-flight
+	// requests are done.
+	select {}
+}
+
+func (k *App) newServer(addr string, h http.Handler) *http.Server {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	s := &http.Server{Addr: addr, Handler: h}
+	s.RegisterOnShutdown(k.kg.Shutdown)
+	k.servers = append(k.servers, s)
+	return s
+}
+
+// Exit shuts down the servers, giving in-flight requests time to finish, and
+// then exits the process with code.
+func (k *App) Exit(code int) {
+	log.Printf("Shutting down with exit code %d", code)
+
+	k.mu.Lock()
+	servers := k.servers
+	k.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(ctx); err != nil {
+			log.Printf("Error shutting down server on %v: %v", s.Addr, err)
+		}
+	}
+	os.Exit(code)
 }
 
 func NewApp() *App {
@@ -164,9 +218,11 @@ func NewApp() *App {
 	k.m = memory.New()
 	k.live = debugprobe.New()
 	k.ready = debugprobe.New()
+	k.started = debugprobe.New()
 	k.env = env.New()
 	k.dns = dnsapi.New()
 	k.kg = keygen.New()
+	k.kg.SetExitFunc(k.Exit)
 	k.mq = memqserver.NewServer()
 
 	// Add handlers
@@ -186,6 +242,7 @@ func NewApp() *App {
 		k.m.AddRoutes(router, prefix+"/mem")
 		k.live.AddRoutes(router, prefix+"/healthy")
 		k.ready.AddRoutes(router, prefix+"/ready")
+		k.started.AddRoutes(router, prefix+"/started")
 		k.env.AddRoutes(router, prefix+"/env")
 		k.dns.AddRoutes(router, prefix+"/dns")
 		k.kg.AddRoutes(router, prefix+"/keygen")
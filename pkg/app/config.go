@@ -16,8 +16,11 @@ limitations under the License.
 package app
 
 import (
+	"log"
+
 	"github.com/kubernetes-up-and-running/kuard/pkg/debugprobe"
 	"github.com/kubernetes-up-and-running/kuard/pkg/keygen"
+	memqserver "github.com/kubernetes-up-and-running/kuard/pkg/memq/server"
 	"github.com/kubernetes-up-and-running/kuard/pkg/sitedata"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -31,16 +34,20 @@ type Config struct {
 	TLSDir       string `mapstructure:"tls-dir"`
 
 	KeyGen keygen.Config
+	MemQ   memqserver.Config
 
 	Liveness  debugprobe.ProbeConfig
 	Readiness debugprobe.ProbeConfig
+	Startup   debugprobe.ProbeConfig
 }
 
 func (k *App) BindConfig(v *viper.Viper, fs *pflag.FlagSet) {
 	k.kg.BindConfig(v, fs)
+	k.mq.BindConfig(v, fs)
 
 	k.live.BindConfig("liveness", v, fs)
 	k.ready.BindConfig("readiness", v, fs)
+	k.started.BindConfig("startup", v, fs)
 
 	fs.Bool("debug", false, "Debug/devel mode")
 	v.BindPFlag("debug", fs.Lookup("debug"))
@@ -60,10 +67,18 @@ func (k *App) LoadConfig(v *viper.Viper) {
 		panic(err)
 	}
 
-	k.live.SetConfig(k.c.Liveness)
-	k.ready.SetConfig(k.c.Readiness)
+	if err := k.live.SetConfig(k.c.Liveness); err != nil {
+		log.Fatalf("Bad liveness probe config: %v", err)
+	}
+	if err := k.ready.SetConfig(k.c.Readiness); err != nil {
+		log.Fatalf("Bad readiness probe config: %v", err)
+	}
+	if err := k.started.SetConfig(k.c.Startup); err != nil {
+		log.Fatalf("Bad startup probe config: %v", err)
+	}
 
 	k.kg.LoadConfig(k.c.KeyGen)
+	k.mq.LoadConfig(k.c.MemQ)
 
 	k.tg.SetConfig(k.c.Debug)
 	sitedata.SetConfig(k.c.Debug, k.c.DebugRootDir)
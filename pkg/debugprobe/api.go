@@ -18,9 +18,13 @@ package debugprobe
 
 // ProbeStatus is returned from a GET to this API endpoing
 type ProbeStatus struct {
-	ProbePath string               `json:"probePath"`
-	FailNext  int                  `json:"failNext"`
-	History   []ProbeStatusHistory `json:"history"`
+	ProbePath string `json:"probePath"`
+
+	// The current config.  FailNext and DelayNext count down as probes are
+	// served.
+	ProbeConfig
+
+	History []ProbeStatusHistory `json:"history"`
 }
 
 // ProbeStatusHistory is a record of a probe call
@@ -29,4 +33,5 @@ type ProbeStatusHistory struct {
 	When    string `json:"when"`
 	RelWhen string `json:"relWhen"`
 	Code    int    `json:"code"`
+	DelayMS int    `json:"delayMS"`
 }
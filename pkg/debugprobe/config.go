@@ -17,6 +17,10 @@ limitations under the License.
 package debugprobe
 
 import (
+	"fmt"
+	"net/http"
+	"strings"
+
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -26,16 +30,148 @@ type ProbeConfig struct {
 	// If failNext > 0, then fail next probe and decrement.  If failNext < 0, then
 	// fail forever.
 	FailNext int `json:"failNext" mapstructure:"fail-next"`
+
+	// Wait DelayMS milliseconds, plus a random extra of up to DelayJitterMS,
+	// before responding.  If DelayNext > 0, then only delay the next probes and
+	// decrement, clearing the delay when it reaches 0.  Otherwise delay every
+	// probe.
+	DelayMS       int `json:"delayMS" mapstructure:"delay-ms"`
+	DelayJitterMS int `json:"delayJitterMS" mapstructure:"delay-jitter-ms"`
+	DelayNext     int `json:"delayNext" mapstructure:"delay-next"`
+
+	// To simulate a slow starting container, fail until SlowStartSeconds after
+	// the process started.  This is meant for the startup probe.
+	SlowStartSeconds int `json:"slowStartSeconds" mapstructure:"slow-start-seconds"`
+
+	// The patterns below make a probe flaky.  A probe fails if any of them say
+	// it should.  They are ignored while FailNext is set.
+
+	// Fail each probe with this probability, between 0 and 1.
+	FailProbability float64 `json:"failProbability" mapstructure:"fail-probability"`
+
+	// If FlapFail > 0, then alternate between FlapSucceed successes and
+	// FlapFail failures.
+	FlapSucceed int `json:"flapSucceed" mapstructure:"flap-succeed"`
+	FlapFail    int `json:"flapFail" mapstructure:"flap-fail"`
+
+	// If FailForSeconds > 0, then fail for that long, starting FailAfterSeconds
+	// after the process started.
+	FailAfterSeconds int `json:"failAfterSeconds" mapstructure:"fail-after-seconds"`
+	FailForSeconds   int `json:"failForSeconds" mapstructure:"fail-for-seconds"`
+
+	// If Sequence is set, then respond with each of these status codes in turn,
+	// looping back to the start at the end.  This overrides the other
+	// patterns.
+	Sequence []int `json:"sequence" mapstructure:"sequence"`
+
+	// Failures respond with FailCode, which defaults to 500.  FailHeaders are
+	// extra headers, such as "Location: /elsewhere" for a redirect, in the
+	// "Name: value" form.  If FailBody is set, it replaces the usual message.
+	// Sequence codes outside of 2xx count as failures for the headers and
+	// body.
+	FailCode    int      `json:"failCode" mapstructure:"fail-code"`
+	FailHeaders []string `json:"failHeaders" mapstructure:"fail-headers"`
+	FailBody    string   `json:"failBody" mapstructure:"fail-body"`
 }
 
-func (p *Probe) SetConfig(c ProbeConfig) {
+func (c *ProbeConfig) validate() error {
+	if c.DelayMS < 0 || c.DelayJitterMS < 0 || c.DelayNext < 0 {
+		return fmt.Errorf("delayMS, delayJitterMS and delayNext can't be negative")
+	}
+	if c.SlowStartSeconds < 0 {
+		return fmt.Errorf("slowStartSeconds can't be negative")
+	}
+	if c.FailProbability < 0 || c.FailProbability > 1 {
+		return fmt.Errorf("failProbability must be between 0 and 1")
+	}
+	if c.FlapSucceed < 0 || c.FlapFail < 0 {
+		return fmt.Errorf("flapSucceed and flapFail can't be negative")
+	}
+	if c.FailAfterSeconds < 0 || c.FailForSeconds < 0 {
+		return fmt.Errorf("failAfterSeconds and failForSeconds can't be negative")
+	}
+	for _, code := range c.Sequence {
+		if code < 100 || code > 599 {
+			return fmt.Errorf("bad status code %d in sequence", code)
+		}
+	}
+	if c.FailCode != 0 && (c.FailCode < 100 || c.FailCode > 599) {
+		return fmt.Errorf("bad failCode %d", c.FailCode)
+	}
+	for _, h := range c.FailHeaders {
+		if i := strings.Index(h, ":"); i < 1 {
+			return fmt.Errorf("bad header %q, must be \"Name: value\"", h)
+		}
+	}
+	return nil
+}
+
+// failCode returns the status code for failures.
+func (c *ProbeConfig) failCode() int {
+	if c.FailCode == 0 {
+		return http.StatusInternalServerError
+	}
+	return c.FailCode
+}
+
+// delaying returns true if the next probe should be delayed.
+func (c *ProbeConfig) delaying() bool {
+	return c.DelayMS > 0 || c.DelayJitterMS > 0
+}
+
+// SetConfig checks c and, if it is valid, makes it the probe config.
+func (p *Probe) SetConfig(c ProbeConfig) error {
+	if err := c.validate(); err != nil {
+		return err
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
 	p.c = c
+	p.probes = 0
+	return nil
 }
 
 func (p *Probe) BindConfig(prefix string, v *viper.Viper, fs *pflag.FlagSet) {
 	fs.Int(prefix+"-fail-next", 0, "Fail the next N probes. 0 is succeed forever. <0 is fail forever.")
 	v.BindPFlag(prefix+".fail-next", fs.Lookup(prefix+"-fail-next"))
+	fs.Int(prefix+"-delay-ms", 0, "Wait this many milliseconds before responding to probes.")
+	v.BindPFlag(prefix+".delay-ms", fs.Lookup(prefix+"-delay-ms"))
+	fs.Int(prefix+"-delay-jitter-ms", 0, "Wait up to this many extra milliseconds, at random, before responding to probes.")
+	v.BindPFlag(prefix+".delay-jitter-ms", fs.Lookup(prefix+"-delay-jitter-ms"))
+	fs.Int(prefix+"-delay-next", 0, "Only delay the next N probes. 0 is delay every probe.")
+	v.BindPFlag(prefix+".delay-next", fs.Lookup(prefix+"-delay-next"))
+	fs.Int(prefix+"-slow-start-seconds", 0, "Fail probes until this many seconds after the process started.")
+	v.BindPFlag(prefix+".slow-start-seconds", fs.Lookup(prefix+"-slow-start-seconds"))
+	fs.Float64(prefix+"-fail-probability", 0, "Fail each probe with this probability, between 0 and 1.")
+	v.BindPFlag(prefix+".fail-probability", fs.Lookup(prefix+"-fail-probability"))
+	fs.Int(prefix+"-flap-succeed", 0, "When flapping, the number of probes to succeed in a row.")
+	v.BindPFlag(prefix+".flap-succeed", fs.Lookup(prefix+"-flap-succeed"))
+	fs.Int(prefix+"-flap-fail", 0, "When flapping, the number of probes to fail in a row. 0 is don't flap.")
+	v.BindPFlag(prefix+".flap-fail", fs.Lookup(prefix+"-flap-fail"))
+	fs.Int(prefix+"-fail-after-seconds", 0, "Start failing probes this many seconds after the process started.")
+	v.BindPFlag(prefix+".fail-after-seconds", fs.Lookup(prefix+"-fail-after-seconds"))
+	fs.Int(prefix+"-fail-for-seconds", 0, "Fail probes for this many seconds. 0 is don't fail for a time.")
+	v.BindPFlag(prefix+".fail-for-seconds", fs.Lookup(prefix+"-fail-for-seconds"))
+	fs.StringSlice(prefix+"-sequence", nil, "Respond with these status codes in turn, looping at the end.")
+	v.BindPFlag(prefix+".sequence", fs.Lookup(prefix+"-sequence"))
+	fs.Int(prefix+"-fail-code", http.StatusInternalServerError, "The status code for failed probes.")
+	v.BindPFlag(prefix+".fail-code", fs.Lookup(prefix+"-fail-code"))
+	fs.StringArray(prefix+"-fail-header", nil, "An extra header for failed probes, as \"Name: value\". Can be repeated.")
+	v.BindFlagValue(prefix+".fail-headers", stringArrayFlag{fs.Lookup(prefix + "-fail-header")})
+	fs.String(prefix+"-fail-body", "", "The body for failed probes. Defaults to a description of the failure.")
+	v.BindPFlag(prefix+".fail-body", fs.Lookup(prefix+"-fail-body"))
+}
+
+// stringArrayFlag binds a pflag StringArray to viper, which only knows how to
+// read a StringSlice.  Both are CSV encoded, so reporting the StringSlice type
+// gets the values back intact, commas and all.
+type stringArrayFlag struct {
+	f *pflag.Flag
 }
+
+func (a stringArrayFlag) HasChanged() bool    { return a.f.Changed }
+func (a stringArrayFlag) Name() string        { return a.f.Name }
+func (a stringArrayFlag) ValueString() string { return a.f.Value.String() }
+func (a stringArrayFlag) ValueType() string   { return "stringSlice" }
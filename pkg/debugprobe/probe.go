@@ -19,7 +19,9 @@ package debugprobe
 import (
 	"encoding/json"
 	"fmt"
+	"math/rand"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -36,18 +38,33 @@ type Probe struct {
 
 	lastID int
 
+	// start is when the process started, for SlowStartSeconds and
+	// FailAfterSeconds.
+	start time.Time
+
+	// probes is the number of probes served since the config was set, for
+	// flapping and sequences.
+	probes int
+
 	c       ProbeConfig
 	history []*ProbeHistory
+
+	// rand picks the random part of delays.  Protected by mu.
+	rand *rand.Rand
 }
 
 type ProbeHistory struct {
-	ID   int
-	When time.Time
-	Code int
+	ID    int
+	When  time.Time
+	Code  int
+	Delay time.Duration
 }
 
 func New() *Probe {
-	return &Probe{}
+	return &Probe{
+		start: time.Now(),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
 }
 
 func (p *Probe) AddRoutes(r *httprouter.Router, base string) {
@@ -69,8 +86,8 @@ func (p *Probe) APIGet(w http.ResponseWriter, r *http.Request, _ httprouter.Para
 
 func (p *Probe) lockedGet(w http.ResponseWriter, r *http.Request) {
 	s := &ProbeStatus{
-		ProbePath: p.basePath,
-		FailNext:  p.c.FailNext,
+		ProbePath:   p.basePath,
+		ProbeConfig: p.c,
 	}
 	l := len(p.history)
 	s.History = make([]ProbeStatusHistory, l)
@@ -80,6 +97,7 @@ func (p *Probe) lockedGet(w http.ResponseWriter, r *http.Request) {
 		h.When = htmlutils.FriendlyTime(v.When)
 		h.RelWhen = htmlutils.RelativeTime(v.When)
 		h.Code = v.Code
+		h.DelayMS = int(v.Delay / time.Millisecond)
 	}
 
 	apiutils.ServeJSON(w, s)
@@ -93,39 +111,142 @@ func (p *Probe) APIPut(w http.ResponseWriter, r *http.Request, params httprouter
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-
-	p.SetConfig(c)
+	err = p.SetConfig(c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	p.APIGet(w, r, params)
 }
 
 func (p *Probe) Handle(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	status, message, headers, delay := p.respond()
+
+	// Don't hold the lock while delaying so the API stays responsive.  If the
+	// caller gives up first, the probe is still recorded.
+	if delay > 0 {
+		t := time.NewTimer(delay)
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			t.Stop()
+		}
+	}
+
+	// Configured headers replace the defaults, such as Content-Type.  A name
+	// can be repeated to send more than one value.  They were checked for a
+	// name when the config was set.
+	w.Header().Set("Content-Type", "text/plain")
+	set := map[string]bool{}
+	for _, h := range headers {
+		i := strings.Index(h, ":")
+		name := http.CanonicalHeaderKey(strings.TrimSpace(h[:i]))
+		value := strings.TrimSpace(h[i+1:])
+		if set[name] {
+			w.Header().Add(name, value)
+		} else {
+			w.Header().Set(name, value)
+			set[name] = true
+		}
+	}
+	w.WriteHeader(status)
+	w.Write([]byte(message))
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.recordRequest(r, status, delay)
+}
+
+// respond works out the response to the next probe and updates the config for
+// the probes that are counted down.  It returns the status code, body, extra
+// headers and how long to wait before responding.
+func (p *Probe) respond() (int, string, []string, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var delay time.Duration
+	if p.c.delaying() {
+		delay = time.Duration(p.c.DelayMS) * time.Millisecond
+		if p.c.DelayJitterMS > 0 {
+			delay += time.Duration(p.rand.Intn(p.c.DelayJitterMS+1)) * time.Millisecond
+		}
+		if p.c.DelayNext > 0 {
+			p.c.DelayNext--
+			if p.c.DelayNext == 0 {
+				p.c.DelayMS = 0
+				p.c.DelayJitterMS = 0
+			}
+		}
+	}
+
+	n := p.probes
+	p.probes++
 
 	status := http.StatusOK
 	message := "ok"
+	failed := true
 	if p.c.FailNext > 0 {
-		status = http.StatusInternalServerError
+		status = p.c.failCode()
 		p.c.FailNext--
 		message = fmt.Sprintf("fail, %d left", p.c.FailNext)
 	} else if p.c.FailNext < 0 {
-		status = http.StatusInternalServerError
+		status = p.c.failCode()
 		message = "fail, permanent"
+	} else if left := p.slowStartLeft(); left > 0 {
+		status = p.c.failCode()
+		message = fmt.Sprintf("fail, starting, %v left", left.Round(time.Second))
+	} else if len(p.c.Sequence) > 0 {
+		i := n % len(p.c.Sequence)
+		status = p.c.Sequence[i]
+		message = fmt.Sprintf("%d of %d in sequence", i+1, len(p.c.Sequence))
+		failed = status < 200 || status > 299
+	} else if reason := p.flaky(n); len(reason) > 0 {
+		status = p.c.failCode()
+		message = "fail, " + reason
+	} else {
+		failed = false
 	}
 
-	w.Header().Set("Content-Type", "text/plain")
-	w.WriteHeader(status)
-	w.Write([]byte(message))
-	p.recordRequest(r, status)
+	if !failed {
+		return status, message, nil, delay
+	}
+	if len(p.c.FailBody) > 0 {
+		message = p.c.FailBody
+	}
+	return status, message, p.c.FailHeaders, delay
+}
+
+// slowStartLeft returns how long until a slow start is done.
+func (p *Probe) slowStartLeft() time.Duration {
+	return time.Duration(p.c.SlowStartSeconds)*time.Second - time.Since(p.start)
+}
+
+// flaky returns why probe n should fail, or "" if it shouldn't.
+func (p *Probe) flaky(n int) string {
+	if p.c.FailForSeconds > 0 {
+		after := time.Duration(p.c.FailAfterSeconds) * time.Second
+		since := time.Since(p.start)
+		if since >= after && since < after+time.Duration(p.c.FailForSeconds)*time.Second {
+			return "in window"
+		}
+	}
+	if p.c.FlapFail > 0 && n%(p.c.FlapSucceed+p.c.FlapFail) >= p.c.FlapSucceed {
+		return "flapping"
+	}
+	if p.c.FailProbability > 0 && p.rand.Float64() < p.c.FailProbability {
+		return "random"
+	}
+	return ""
 }
 
-func (p *Probe) recordRequest(_ *http.Request, code int) {
+func (p *Probe) recordRequest(_ *http.Request, code int, delay time.Duration) {
 	p.lastID++
 	entry := &ProbeHistory{
-		ID:   p.lastID,
-		When: time.Now(),
-		Code: code,
+		ID:    p.lastID,
+		When:  time.Now(),
+		Code:  code,
+		Delay: delay,
 	}
 	p.history = append(p.history, entry)
 	if len(p.history) > maxHistory {
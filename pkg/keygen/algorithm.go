@@ -0,0 +1,126 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"sort"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// DefaultAlgorithm is used when no algorithm is configured.
+const DefaultAlgorithm = "rsa-4096"
+
+// algorithms maps an algorithm name to a function that generates a private key
+// of that type.  The costs vary wildly: RSA keys are by far the most expensive
+// (and get more so with size) while Ed25519 keys are very cheap.
+var algorithms = map[string]func() (interface{}, error){
+	"rsa-2048":   rsaGenerator(2048),
+	"rsa-3072":   rsaGenerator(3072),
+	"rsa-4096":   rsaGenerator(4096),
+	"ecdsa-p256": ecdsaGenerator(elliptic.P256()),
+	"ecdsa-p384": ecdsaGenerator(elliptic.P384()),
+	"ecdsa-p521": ecdsaGenerator(elliptic.P521()),
+	"ed25519": func() (interface{}, error) {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	},
+}
+
+func rsaGenerator(bits int) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		return rsa.GenerateKey(rand.Reader, bits)
+	}
+}
+
+func ecdsaGenerator(c elliptic.Curve) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		return ecdsa.GenerateKey(c, rand.Reader)
+	}
+}
+
+// Algorithms returns the names of all supported key algorithms, sorted.
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkAlgorithm(alg string) error {
+	if _, ok := algorithms[alg]; !ok {
+		return fmt.Errorf("unknown algorithm %q, must be one of %v", alg, Algorithms())
+	}
+	return nil
+}
+
+// key is a generated key pair.
+type key struct {
+	algorithm string
+	private   interface{}
+	public    ssh.PublicKey
+}
+
+func generateKey(alg string) (*key, error) {
+	gen, ok := algorithms[alg]
+	if !ok {
+		return nil, checkAlgorithm(alg)
+	}
+
+	private, err := gen()
+	if err != nil {
+		return nil, fmt.Errorf("error generating key: %v", err)
+	}
+
+	var public interface{}
+	switch k := private.(type) {
+	case *rsa.PrivateKey:
+		public = &k.PublicKey
+	case *ecdsa.PrivateKey:
+		public = &k.PublicKey
+	case ed25519.PrivateKey:
+		public = k.Public()
+	}
+	pub, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, fmt.Errorf("error generating ssh key: %v", err)
+	}
+
+	return &key{
+		algorithm: alg,
+		private:   private,
+		public:    pub,
+	}, nil
+}
+
+func (k *key) fingerprint() string {
+	return ssh.FingerprintSHA256(k.public)
+}
+
+// describe returns a one line description of the key for the workload
+// history.
+func (k *key) describe() string {
+	return fmt.Sprintf("%s %s", k.algorithm, k.fingerprint())
+}
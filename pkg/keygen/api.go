@@ -26,8 +26,24 @@ import (
 
 // ProbeStatus is returned from a GET to this API endpoing
 type KeyGenStatus struct {
-	Config  Config    `json:"config"`
-	History []History `json:"history"`
+	Name   string `json:"name"`
+	Config Config `json:"config"`
+
+	// State is the lifecycle state of the current (or last) workload.  The
+	// counters cover that workload and time spent paused isn't included.
+	// Remaining is an estimate and is only set when the workload has a limit.
+	State     State    `json:"state"`
+	Error     string   `json:"error,omitempty"`
+	Generated int64    `json:"generated"`
+	Errors    int64    `json:"errors"`
+	Elapsed   float64  `json:"elapsedSeconds"`
+	Remaining *float64 `json:"remainingSeconds,omitempty"`
+
+	Items *ItemStats `json:"items,omitempty"`
+
+	CPU     *CPUStatus  `json:"cpu,omitempty"`
+	Rate    *RateStatus `json:"rate,omitempty"`
+	History []History   `json:"history"`
 }
 
 type History struct {
@@ -35,6 +51,40 @@ type History struct {
 	Data string `json:"data"`
 }
 
+// WorkloadList is returned from a GET to the list of workloads.
+type WorkloadList struct {
+	Workloads []*KeyGenStatus `json:"workloads"`
+}
+
+// APIGenerate generates a single key and returns it.  This doesn't touch the
+// workloads.
+func (kg *KeyGen) APIGenerate(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	req := GenerateRequest{}
+	if r.ContentLength != 0 {
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	err := req.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := generate(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	apiutils.ServeJSON(w, resp)
+}
+
+// The handlers below serve both the default workload, at the base of the API,
+// and named workloads, where the name is a route parameter.
+
 func (kg *KeyGen) APIPut(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
 	c := Config{}
 
@@ -43,20 +93,78 @@ func (kg *KeyGen) APIPut(w http.ResponseWriter, r *http.Request, params httprout
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	err = c.validate()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	kg.LoadConfig(c)
+	if name := params.ByName("name"); len(name) > 0 {
+		err = kg.PutWorkload(name, c)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		kg.LoadConfig(c)
+	}
 
 	kg.APIGet(w, r, params)
 }
 
-func (kg *KeyGen) APIGet(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	kg.mu.Lock()
-	defer kg.mu.Unlock()
+func (kg *KeyGen) APIGet(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	rn, err := kg.workload(params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	apiutils.ServeJSON(w, rn.status())
+}
+
+func (kg *KeyGen) APIListWorkloads(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	apiutils.ServeJSON(w, &WorkloadList{Workloads: kg.Workloads()})
+}
 
-	s := &KeyGenStatus{
-		Config:  kg.config,
-		History: kg.history,
+func (kg *KeyGen) APIDeleteWorkload(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	err := kg.DeleteWorkload(params.ByName("name"))
+	switch err {
+	case nil:
+	case ErrWorkloadNotExist:
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		http.Error(w, err.Error(), http.StatusBadRequest)
 	}
+}
 
-	apiutils.ServeJSON(w, s)
+func (kg *KeyGen) APIStart(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	kg.serveControl((*runner).start, w, r, params)
+}
+
+func (kg *KeyGen) APIStop(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	kg.serveControl((*runner).stop, w, r, params)
+}
+
+func (kg *KeyGen) APIPause(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	kg.serveControl((*runner).pause, w, r, params)
+}
+
+func (kg *KeyGen) APIResume(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	kg.serveControl((*runner).resume, w, r, params)
+}
+
+// serveControl runs a state change and returns the new status.  Changes that
+// don't make sense in the current state are a conflict.
+func (kg *KeyGen) serveControl(f func(*runner) error, w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	rn, err := kg.workload(params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	err = f(rn)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	apiutils.ServeJSON(w, rn.status())
 }
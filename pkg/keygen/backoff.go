@@ -0,0 +1,82 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// random is shared by everything in this package that needs randomness.  The
+// global math/rand source isn't seeded.
+var random = struct {
+	sync.Mutex
+	*rand.Rand
+}{Rand: rand.New(rand.NewSource(time.Now().UnixNano()))}
+
+func randomInt63n(n int64) int64 {
+	random.Lock()
+	defer random.Unlock()
+	return random.Int63n(n)
+}
+
+// backoff is an exponential backoff with jitter.  Each wait is a random time
+// between half and all of the current delay, which then doubles up to max.
+type backoff struct {
+	min, max time.Duration
+	delay    time.Duration
+}
+
+// Retries of MemQ requests start at minRetryDelay and back off to
+// maxRetryDelay.
+const (
+	minRetryDelay = 500 * time.Millisecond
+	maxRetryDelay = 30 * time.Second
+)
+
+func newBackoff() *backoff {
+	return &backoff{min: minRetryDelay, max: maxRetryDelay, delay: minRetryDelay}
+}
+
+// next returns how long to wait before the next retry.
+func (b *backoff) next() time.Duration {
+	d := b.delay/2 + time.Duration(randomInt63n(int64(b.delay/2)+1))
+	b.delay *= 2
+	if b.delay > b.max {
+		b.delay = b.max
+	}
+	return d
+}
+
+// reset is called after a success.
+func (b *backoff) reset() {
+	b.delay = b.min
+}
+
+// sleep waits for d or until ctx is done.  It returns false if ctx is done.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -17,44 +17,175 @@ limitations under the License.
 package keygen
 
 import (
+	"fmt"
 	"strings"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
+// DefaultItemSizeMB is used when no item size is configured.
+const DefaultItemSizeMB = 16
+
+// DefaultHistorySize is used when no history size is configured.
+const DefaultHistorySize = 20
+
+// DefaultMemQErrorExitCode is used when no exit code is configured for a used
+// up error budget.  It isn't 0 so that a Job counts the pod as failed.
+const DefaultMemQErrorExitCode = 2
+
+const (
+	RateUnitSecond = "second"
+	RateUnitMinute = "minute"
+)
+
+// Where the work for a workload comes from.  See Config.Mode.
+const (
+	ModeLocal    = "local"
+	ModeMemQ     = "memq"
+	ModeProducer = "producer"
+)
+
+// How to pick between multiple MemQ queues.  See Config.MemQStrategy.
+const (
+	StrategyPriority = "priority"
+	StrategyWeighted = "weighted"
+)
+
 // Config is the input parameters to the keygen workload.
 type Config struct {
 	Enable bool `json:"enable"`
 
+	// The kind of work to do for each item.  See Kinds() for the options.
+	// "keygen" generates a key pair, "sha256" hashes a buffer, "memory"
+	// allocates a buffer and lets it go and "disk" writes a file in DiskDir and
+	// reads it back.  ItemSizeMB is the size of the buffer or file.
+	Kind       string `json:"kind" mapstructure:"kind"`
+	ItemSizeMB int    `json:"itemSizeMB" mapstructure:"item-size-mb"`
+	DiskDir    string `json:"diskDir" mapstructure:"disk-dir"`
+
+	// The type of key to generate.  See Algorithms() for the options.  RSA keys
+	// are much more expensive to generate than ECDSA or Ed25519 keys.
+	Algorithm string `json:"algorithm" mapstructure:"algorithm"`
+
+	// If OutputDir is set, each generated key is written there, along with a
+	// manifest of fingerprints.  Only public keys are written unless
+	// OutputPrivateKeys is set.
+	OutputDir         string `json:"outputDir" mapstructure:"output-dir"`
+	OutputPrivateKeys bool   `json:"outputPrivateKeys" mapstructure:"output-private-keys"`
+
 	// This limits the amount of work to do.  The workload will stop when either
 	// of these is complete.  Zero is interpreted as "infinity".  TimeToRun is in
 	// seconds.
 	NumToGen  int `json:"numToGen" mapstructure:"num-to-gen"`
 	TimeToRun int `json:"timeToRun" mapstructure:"time-to-run"`
 
+	// When running as one pod of a Kubernetes Indexed Job, set Completions to
+	// the number of pods and CompletionIndex to this pod's index (from
+	// JOB_COMPLETION_INDEX).  Each pod then generates its share of NumToGen
+	// with any remainder going to the lowest indexes.  Zero Completions turns
+	// this off.
+	CompletionIndex int `json:"completionIndex" mapstructure:"completion-index"`
+	Completions     int `json:"completions" mapstructure:"completions"`
+
+	// The number of workers generating keys concurrently.  Each worker can keep
+	// a core busy.  The workers share the NumToGen and TimeToRun limits.
+	Parallelism int `json:"parallelism" mapstructure:"parallelism"`
+
+	// If TargetCPU is set, the workers alternate between work and sleep to keep
+	// CPU usage at this percentage.  Normally this is a percentage of a core
+	// for each worker.  If TargetCPUOfQuota is set then it is a percentage of
+	// the container's CPU limit (cgroup quota) spread across all workers.
+	// These can be changed without restarting the workload.
+	TargetCPU        int  `json:"targetCPU" mapstructure:"target-cpu"`
+	TargetCPUOfQuota bool `json:"targetCPUOfQuota" mapstructure:"target-cpu-of-quota"`
+
+	// If Rate is set, keys are generated at this rate (per RateUnit) across
+	// all workers rather than as fast as possible.  RateUnit is "second" or
+	// "minute".  These can be changed without restarting the workload.
+	Rate     float64 `json:"rate" mapstructure:"rate"`
+	RateUnit string  `json:"rateUnit" mapstructure:"rate-unit"`
+
+	// Mode is one of:
+	//  * "local": generate keys until NumToGen or TimeToRun is reached.
+	//  * "memq": pull work items off of MemQQueue (see below).
+	//  * "producer": enqueue NumToGen work items (one key each) to MemQQueue
+	//    for "memq" workloads to consume, creating the queue if needed.  This
+	//    also stops at TimeToRun and honors Rate.
+	// If it is empty, the mode is "memq" if the MemQ server and queue are set
+	// and "local" otherwise.
+	Mode string `json:"mode" mapstructure:"mode"`
+
 	// If both of these variables are set, then the keygen worker will pull work
 	// items off of the MemQ.  If there is an error it will keep retrying with a
 	// small pause.  If the queue is empty, and exitOnComplete is set, then the
 	// process will exit.  If MemQ is used, then NumToGen and TimeToRun are
-	// ignored.
+	// ignored.  In producer mode, work items are added to the queue instead.
 	MemQServer string `json:"memQServer" mapstructure:"memq-server"`
 	MemQQueue  string `json:"memQQueue" mapstructure:"memq-queue"`
 
+	// MemQQueue can also be a comma separated list of queues to consume from,
+	// each with an optional weight, such as "high=3,low=1".  With the
+	// "priority" strategy, queues are tried in the order listed.  With
+	// "weighted", a queue is picked at random in proportion to its weight
+	// (trying the others if it is empty).
+	MemQStrategy string `json:"memQStrategy" mapstructure:"memq-strategy"`
+
+	// If MemQErrorBudget is set, the worker gives up after this many errors
+	// (talking to the server or doing work items) and the process exits with
+	// MemQErrorExitCode (DefaultMemQErrorExitCode if 0).
+	MemQErrorBudget   int `json:"memQErrorBudget" mapstructure:"memq-error-budget"`
+	MemQErrorExitCode int `json:"memQErrorExitCode" mapstructure:"memq-error-exit-code"`
+
+	// If set, a result message is published to this queue on MemQServer for
+	// each work item.  The queue is created if it doesn't exist.
+	MemQResultsQueue string `json:"memQResultsQueue" mapstructure:"memq-results-queue"`
+
+	// The number of lines of workload output kept for the status and for
+	// streams to resume from.  This can be changed without restarting the
+	// workload.
+	HistorySize int `json:"historySize" mapstructure:"history-size"`
+
 	// What should happen when the workload is complete?
 	ExitOnComplete bool `json:"exitOnComplete" mapstructure:"exit-on-complete"`
 	ExitCode       int  `json:"exitCode" mapstructure:"exit-code"`
+
+	// When exiting on completion, a summary of the workload is written here.
+	// Kubernetes shows it as the termination message for the container.  The
+	// file must already exist, as it does when the kubelet mounts it, so that
+	// nothing is created under /dev outside of Kubernetes.
+	TerminationMessagePath string `json:"terminationMessagePath" mapstructure:"termination-message-path"`
 }
 
 func (kg *KeyGen) BindConfig(v *viper.Viper, fs *pflag.FlagSet) {
 	v.Set("keygen", map[string]interface{}{})
 	fs.Bool("keygen-enable", false, "Enable KeyGen workload")
+	fs.String("keygen-kind", DefaultKind, fmt.Sprintf("The kind of work to do for each item. One of %v", Kinds()))
+	fs.Int("keygen-item-size-mb", DefaultItemSizeMB, "The size in MiB of the buffer or file for the sha256, memory and disk kinds")
+	fs.String("keygen-disk-dir", "", "Where the disk kind writes files. Defaults to the system temp directory")
+	fs.String("keygen-algorithm", DefaultAlgorithm, fmt.Sprintf("The type of key to generate. One of %v", Algorithms()))
+	fs.String("keygen-output-dir", "", "Write generated keys and a manifest of fingerprints to this directory. Set to empty to not write keys")
+	fs.Bool("keygen-output-private-keys", false, "Also write private keys to the output directory")
 	fs.Int("keygen-num-to-gen", 0, "The number of keys to generate. Set to 0 for infinite")
 	fs.Int("keygen-time-to-run", 0, "The target run time in seconds. Set to 0 for infinite")
+	fs.Int("keygen-completion-index", 0, "The index of this pod in an Indexed Job. Defaults to $JOB_COMPLETION_INDEX")
+	fs.Int("keygen-completions", 0, "The number of pods in an Indexed Job to split the number of keys between. Set to 0 to not split")
+	fs.Int("keygen-parallelism", 1, "The number of concurrent workers generating keys")
+	fs.Int("keygen-target-cpu", 0, "Throttle workers to this CPU percentage. Set to 0 for unthrottled")
+	fs.Bool("keygen-target-cpu-of-quota", false, "Treat the target CPU as a percentage of the container CPU limit rather than per worker")
+	fs.Float64("keygen-rate", 0, "The number of keys to generate per rate unit. Set to 0 for unlimited")
+	fs.String("keygen-rate-unit", RateUnitSecond, "The unit for the rate. One of second or minute")
+	fs.String("keygen-mode", "", "Where work comes from. One of local, memq or producer. Defaults to memq if the MemQ server and queue are set, otherwise local")
 	fs.String("keygen-memq-server", "", "The MemQ server to draw work items from.  If MemQ is used, other limits are ignored.")
 	fs.String("keygen-memq-queue", "", "The MemQ server queue to use. If MemQ is used, other limits are ignored.")
+	fs.String("keygen-memq-strategy", StrategyPriority, "How to pick between multiple MemQ queues. One of priority or weighted")
+	fs.Int("keygen-memq-error-budget", 0, "Give up after this many MemQ errors. Set to 0 for unlimited")
+	fs.Int("keygen-memq-error-exit-code", DefaultMemQErrorExitCode, "Exit code when the MemQ error budget is used up")
+	fs.String("keygen-memq-results-queue", "", "The MemQ server queue to publish work item results to.")
+	fs.Int("keygen-history-size", DefaultHistorySize, "The number of lines of workload output to keep")
 	fs.Bool("keygen-exit-on-complete", false, "Exit after workload is complete")
 	fs.Int("keygen-exit-code", 0, "Exit code when workload complete")
+	fs.String("keygen-termination-message-path", "/dev/termination-log", "Where to write a summary of the workload when exiting on completion, if the file exists. Set to empty to disable")
 
 	// Iterate through all flags and register with the passed in viper.  Only
 	// apply to those flags with our prefix but strip it out.
@@ -64,10 +195,186 @@ func (kg *KeyGen) BindConfig(v *viper.Viper, fs *pflag.FlagSet) {
 			v.BindPFlag("keygen."+name, f)
 		}
 	})
+
+	// Kubernetes sets this for pods in an Indexed Job.
+	v.BindEnv("keygen.completion-index", "JOB_COMPLETION_INDEX")
+}
+
+// validate checks for config values that can never work.
+func (c *Config) validate() error {
+	if len(c.Algorithm) > 0 {
+		if err := checkAlgorithm(c.Algorithm); err != nil {
+			return err
+		}
+	}
+	if len(c.Kind) > 0 {
+		if err := checkKind(c.Kind); err != nil {
+			return err
+		}
+	}
+	if c.ItemSizeMB < 0 {
+		return fmt.Errorf("itemSizeMB must not be negative")
+	}
+	if c.TargetCPU < 0 || c.TargetCPU > 100 {
+		return fmt.Errorf("targetCPU must be between 0 and 100")
+	}
+	if c.Completions < 0 {
+		return fmt.Errorf("completions must not be negative")
+	}
+	if c.Completions > 0 && (c.CompletionIndex < 0 || c.CompletionIndex >= c.Completions) {
+		return fmt.Errorf("completionIndex must be between 0 and %d", c.Completions-1)
+	}
+	switch c.Mode {
+	case "", ModeLocal:
+	case ModeMemQ, ModeProducer:
+		if len(c.MemQServer) == 0 || len(c.MemQQueue) == 0 {
+			return fmt.Errorf("mode %s needs memQServer and memQQueue", c.Mode)
+		}
+	default:
+		return fmt.Errorf("mode must be one of %s, %s or %s", ModeLocal, ModeMemQ, ModeProducer)
+	}
+	if len(c.MemQQueue) > 0 {
+		queues, err := parseQueues(c.MemQQueue)
+		if err != nil {
+			return err
+		}
+		if c.mode() == ModeProducer && len(queues) > 1 {
+			return fmt.Errorf("producers can only fill a single queue")
+		}
+	}
+	switch c.MemQStrategy {
+	case "", StrategyPriority, StrategyWeighted:
+	default:
+		return fmt.Errorf("memQStrategy must be %q or %q", StrategyPriority, StrategyWeighted)
+	}
+	if c.MemQErrorBudget < 0 {
+		return fmt.Errorf("memQErrorBudget must not be negative")
+	}
+	if c.HistorySize < 0 {
+		return fmt.Errorf("historySize must not be negative")
+	}
+	if c.Rate < 0 {
+		return fmt.Errorf("rate must not be negative")
+	}
+	switch c.RateUnit {
+	case "", RateUnitSecond, RateUnitMinute:
+	default:
+		return fmt.Errorf("rateUnit must be %q or %q", RateUnitSecond, RateUnitMinute)
+	}
+	return nil
+}
+
+// onlyLiveChanges returns true if there are differences between c and o and
+// they can all be applied without restarting the workload.
+func (c Config) onlyLiveChanges(o Config) bool {
+	if c == o {
+		return false
+	}
+	c.TargetCPU, o.TargetCPU = 0, 0
+	c.TargetCPUOfQuota, o.TargetCPUOfQuota = false, false
+	c.Rate, o.Rate = 0, 0
+	c.RateUnit, o.RateUnit = "", ""
+	c.HistorySize, o.HistorySize = 0, 0
+	return c == o
+}
+
+// numToGen returns this pod's share of NumToGen.  It is only meaningful if
+// NumToGen is set and can be zero if there are more pods than keys.
+func (c *Config) numToGen() int {
+	if c.Completions <= 0 {
+		return c.NumToGen
+	}
+	n := c.NumToGen / c.Completions
+	if c.CompletionIndex < c.NumToGen%c.Completions {
+		n++
+	}
+	return n
+}
+
+func (c *Config) rateUnit() string {
+	if len(c.RateUnit) == 0 {
+		return RateUnitSecond
+	}
+	return c.RateUnit
+}
+
+// ratePerSecond returns the configured rate in keys per second.
+func (c *Config) ratePerSecond() float64 {
+	if c.rateUnit() == RateUnitMinute {
+		return c.Rate / 60
+	}
+	return c.Rate
+}
+
+func (c *Config) historySize() int {
+	if c.HistorySize <= 0 {
+		return DefaultHistorySize
+	}
+	return c.HistorySize
+}
+
+func (c *Config) memQErrorExitCode() int {
+	if c.MemQErrorExitCode == 0 {
+		return DefaultMemQErrorExitCode
+	}
+	return c.MemQErrorExitCode
+}
+
+// memQQueue returns the name of the single queue a producer fills.
+func (c *Config) memQQueue() string {
+	queues, err := parseQueues(c.MemQQueue)
+	if err != nil {
+		return c.MemQQueue
+	}
+	return queues[0].name
+}
+
+func (c *Config) kind() string {
+	if len(c.Kind) == 0 {
+		return DefaultKind
+	}
+	return c.Kind
 }
 
-func (kg *KeyGen) LoadConfig(c Config) {
-	kg.config = c
+// itemParams returns the parameters for each item of work.
+func (c *Config) itemParams() ItemParams {
+	size := c.ItemSizeMB
+	if size <= 0 {
+		size = DefaultItemSizeMB
+	}
+	return ItemParams{
+		Algorithm: c.Algorithm,
+		Size:      size << 20,
+		Dir:       c.DiskDir,
+
+		OutputDir:         c.OutputDir,
+		OutputPrivateKeys: c.OutputPrivateKeys,
+	}
+}
+
+// describeWork returns a short description of each item of work.
+func (c *Config) describeWork() string {
+	if c.kind() == DefaultKind {
+		return c.Algorithm
+	}
+	return fmt.Sprintf("%s %d MiB", c.kind(), c.itemParams().Size>>20)
+}
+
+// mode returns the configured mode, working it out from the MemQ settings if
+// it isn't set.
+func (c *Config) mode() string {
+	if len(c.Mode) > 0 {
+		return c.Mode
+	}
+	if len(c.MemQQueue) > 0 && len(c.MemQServer) > 0 {
+		return ModeMemQ
+	}
+	return ModeLocal
+}
 
-	kg.Restart()
+func (c *Config) parallelism() int {
+	if c.Parallelism < 1 {
+		return 1
+	}
+	return c.Parallelism
 }
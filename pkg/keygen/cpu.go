@@ -0,0 +1,191 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"context"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dutySlice is roughly how much work a worker does before it sleeps to bring
+// its CPU usage down to the target.  A single key can take longer than this in
+// which case the worker sleeps after every key.
+const dutySlice = 100 * time.Millisecond
+
+// CPUStatus reports how the workload is being throttled to hit a CPU target.
+type CPUStatus struct {
+	TargetPercent int  `json:"targetPercent"`
+	OfQuota       bool `json:"ofQuota"`
+
+	// QuotaCores is the CPU limit of the container, or the number of CPUs if
+	// there is no limit.  It is only set if OfQuota is set.
+	QuotaCores float64 `json:"quotaCores,omitempty"`
+
+	// DutyCycle is the fraction of time each worker is busy.  TargetCores is
+	// how many cores that adds up to across all workers.
+	DutyCycle   float64 `json:"dutyCycle"`
+	TargetCores float64 `json:"targetCores"`
+
+	// MeasuredCores is the average number of cores kept busy since the target
+	// was last set.
+	MeasuredCores float64 `json:"measuredCores"`
+}
+
+// cpuShaper holds workers at a target CPU utilization by having them alternate
+// between generating keys and sleeping.  The target can be changed while the
+// workload is running.
+type cpuShaper struct {
+	mu      sync.Mutex
+	status  CPUStatus
+	workers int
+	busy    time.Duration
+	since   time.Time
+}
+
+func newCPUShaper(c Config) *cpuShaper {
+	s := &cpuShaper{}
+	s.set(c)
+	return s
+}
+
+// set recomputes the duty cycle from c and resets the measurements.
+func (s *cpuShaper) set(c Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.workers = c.parallelism()
+	s.status = CPUStatus{
+		TargetPercent: c.TargetCPU,
+		OfQuota:       c.TargetCPUOfQuota,
+	}
+	if c.TargetCPU > 0 {
+		duty := float64(c.TargetCPU) / 100
+		if c.TargetCPUOfQuota {
+			s.status.QuotaCores = cpuQuota()
+			duty = duty * s.status.QuotaCores / float64(s.workers)
+		}
+		if duty > 1 {
+			duty = 1
+		}
+		s.status.DutyCycle = duty
+	} else {
+		s.status.DutyCycle = 1
+	}
+	s.status.TargetCores = s.status.DutyCycle * float64(s.workers)
+	s.busy = 0
+	s.since = time.Now()
+}
+
+func (s *cpuShaper) dutyCycle() float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status.DutyCycle
+}
+
+func (s *cpuShaper) record(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.busy += d
+}
+
+func (s *cpuShaper) getStatus() *CPUStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := s.status
+	if elapsed := time.Since(s.since); elapsed > 0 {
+		status.MeasuredCores = s.busy.Seconds() / elapsed.Seconds()
+	}
+	return &status
+}
+
+// throttle is the per worker side of a cpuShaper.
+type throttle struct {
+	s    *cpuShaper
+	busy time.Duration
+}
+
+func (s *cpuShaper) newThrottle() *throttle {
+	return &throttle{s: s}
+}
+
+// worked records that the worker was busy for d and sleeps if it is time to
+// give back some CPU.
+func (t *throttle) worked(ctx context.Context, d time.Duration) {
+	t.s.record(d)
+
+	duty := t.s.dutyCycle()
+	if duty >= 1 {
+		t.busy = 0
+		return
+	}
+
+	t.busy += d
+	if t.busy < dutySlice {
+		return
+	}
+	sleep := time.Duration(float64(t.busy) * (1 - duty) / duty)
+	t.busy = 0
+
+	select {
+	case <-time.After(sleep):
+	case <-ctx.Done():
+	}
+}
+
+// cpuQuota returns the number of cores this container is limited to by its
+// cgroup.  If there is no limit, or it can't be read, the number of CPUs is
+// returned.
+func cpuQuota() float64 {
+	// cgroup v2
+	if b, err := ioutil.ReadFile("/sys/fs/cgroup/cpu.max"); err == nil {
+		f := strings.Fields(string(b))
+		if len(f) == 2 && f[0] != "max" {
+			if q, ok := parseQuota(f[0], f[1]); ok {
+				return q
+			}
+		}
+		return float64(runtime.NumCPU())
+	}
+
+	// cgroup v1
+	quota, err1 := ioutil.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+	period, err2 := ioutil.ReadFile("/sys/fs/cgroup/cpu/cpu.cfs_period_us")
+	if err1 == nil && err2 == nil {
+		if q, ok := parseQuota(strings.TrimSpace(string(quota)), strings.TrimSpace(string(period))); ok {
+			return q
+		}
+	}
+	return float64(runtime.NumCPU())
+}
+
+func parseQuota(quota, period string) (float64, bool) {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0, false
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return q / p, true
+}
@@ -15,7 +15,11 @@ limitations under the License.
 */
 
 // Package keygen is a sample workload for our demo server.  As a sample time
-// consuming work load, this package generates RSA private/public key pairs.
+// consuming work load, this package generates private/public key pairs.  RSA,
+// ECDSA and Ed25519 keys are supported, each with a very different CPU cost.
+// Other kinds of work (hashing, memory churn and disk I/O) can be plugged in
+// through the Kind interface.
 //
-// See the Config struct for a set of parameters for this workload.
+// See the Config struct for a set of parameters for this workload.  Several
+// workloads, each with their own Config, can run at once.
 package keygen
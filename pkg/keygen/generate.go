@@ -0,0 +1,196 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// Formats for keys from the generate API.
+const (
+	FormatPEM     = "pem"
+	FormatOpenSSH = "openssh"
+	FormatJWK     = "jwk"
+)
+
+// generateMode is the mode label for keys generated on demand.
+const generateMode = "api"
+
+// GenerateRequest is the body of a POST to the generate API.  Empty fields
+// default to DefaultAlgorithm and FormatPEM.
+type GenerateRequest struct {
+	Algorithm string `json:"algorithm"`
+	Format    string `json:"format"`
+}
+
+// GenerateResponse is a freshly generated key.
+//
+// For FormatPEM, PublicKey is a PKIX "PUBLIC KEY" and PrivateKey is a PKCS #8
+// "PRIVATE KEY".  For FormatOpenSSH, PublicKey is an authorized_keys line and
+// PrivateKey is the same PKCS #8 PEM, which OpenSSH can read.  For FormatJWK,
+// both are JSON Web Key objects (RFC 7517) rather than strings.
+type GenerateResponse struct {
+	Algorithm   string      `json:"algorithm"`
+	Format      string      `json:"format"`
+	PublicKey   interface{} `json:"publicKey"`
+	PrivateKey  interface{} `json:"privateKey"`
+	Fingerprint string      `json:"fingerprint"`
+	Duration    float64     `json:"durationSeconds"`
+}
+
+// validate fills in the defaults and checks the request.
+func (req *GenerateRequest) validate() error {
+	if len(req.Algorithm) == 0 {
+		req.Algorithm = DefaultAlgorithm
+	}
+	if len(req.Format) == 0 {
+		req.Format = FormatPEM
+	}
+	if err := checkAlgorithm(req.Algorithm); err != nil {
+		return err
+	}
+	switch req.Format {
+	case FormatPEM, FormatOpenSSH, FormatJWK:
+	default:
+		return fmt.Errorf("unknown format %q, must be one of %s, %s or %s", req.Format, FormatPEM, FormatOpenSSH, FormatJWK)
+	}
+	return nil
+}
+
+// generate creates a key as described by a validated request.
+func generate(req GenerateRequest) (*GenerateResponse, error) {
+	start := time.Now()
+	k, err := generateKey(req.Algorithm)
+	d := time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	recordKey(req.Algorithm, generateMode, d)
+
+	resp := &GenerateResponse{
+		Algorithm:   req.Algorithm,
+		Format:      req.Format,
+		Fingerprint: k.fingerprint(),
+		Duration:    d.Seconds(),
+	}
+	switch req.Format {
+	case FormatJWK:
+		resp.PublicKey, resp.PrivateKey = k.jwk()
+		return resp, nil
+	case FormatOpenSSH:
+		resp.PublicKey = string(k.authorizedKey())
+	default:
+		pub, err := k.publicPEM()
+		if err != nil {
+			return nil, err
+		}
+		resp.PublicKey = string(pub)
+	}
+	priv, err := k.privatePEM()
+	if err != nil {
+		return nil, err
+	}
+	resp.PrivateKey = string(priv)
+	return resp, nil
+}
+
+// JWK is a JSON Web Key (RFC 7517).  Only the fields for the supported key
+// types are included.
+type JWK struct {
+	KeyType string `json:"kty"`
+	Curve   string `json:"crv,omitempty"`
+
+	// RSA public key
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	// EC and OKP public key
+	X string `json:"x,omitempty"`
+	Y string `json:"y,omitempty"`
+
+	// Private key
+	D  string `json:"d,omitempty"`
+	P  string `json:"p,omitempty"`
+	Q  string `json:"q,omitempty"`
+	DP string `json:"dp,omitempty"`
+	DQ string `json:"dq,omitempty"`
+	QI string `json:"qi,omitempty"`
+}
+
+// jwk returns the public and private halves of the key as JWKs.
+func (k *key) jwk() (*JWK, *JWK) {
+	var pub JWK
+	switch priv := k.private.(type) {
+	case *rsa.PrivateKey:
+		pub = JWK{
+			KeyType: "RSA",
+			N:       b64(priv.N.Bytes()),
+			E:       b64(big.NewInt(int64(priv.E)).Bytes()),
+		}
+		private := pub
+		private.D = b64(priv.D.Bytes())
+		if len(priv.Primes) == 2 {
+			priv.Precompute()
+			private.P = b64(priv.Primes[0].Bytes())
+			private.Q = b64(priv.Primes[1].Bytes())
+			private.DP = b64(priv.Precomputed.Dp.Bytes())
+			private.DQ = b64(priv.Precomputed.Dq.Bytes())
+			private.QI = b64(priv.Precomputed.Qinv.Bytes())
+		}
+		return &pub, &private
+	case *ecdsa.PrivateKey:
+		// Coordinates are padded to the size of the curve.
+		size := (priv.Curve.Params().BitSize + 7) / 8
+		pub = JWK{
+			KeyType: "EC",
+			Curve:   priv.Curve.Params().Name,
+			X:       b64(pad(priv.X.Bytes(), size)),
+			Y:       b64(pad(priv.Y.Bytes(), size)),
+		}
+		private := pub
+		private.D = b64(pad(priv.D.Bytes(), size))
+		return &pub, &private
+	case ed25519.PrivateKey:
+		pub = JWK{
+			KeyType: "OKP",
+			Curve:   "Ed25519",
+			X:       b64(priv.Public().(ed25519.PublicKey)),
+		}
+		private := pub
+		private.D = b64(priv.Seed())
+		return &pub, &private
+	}
+	return nil, nil
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
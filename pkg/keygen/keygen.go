@@ -17,95 +17,177 @@ limitations under the License.
 package keygen
 
 import (
-	"context"
-	"crypto/rand"
-	"crypto/rsa"
-	"fmt"
-	"log"
+	"errors"
+	"os"
+	"regexp"
+	"sort"
 	"sync"
 
-	"golang.org/x/crypto/ssh"
-
 	"github.com/julienschmidt/httprouter"
 )
 
-const maxHistory = 20
+// DefaultWorkload is the name of the workload configured by flags and served at
+// the base of the API.
+const DefaultWorkload = "default"
+
+var ErrWorkloadNotExist = errors.New("workload does not exist")
+var ErrDeleteDefault = errors.New("the default workload can't be deleted")
+var ErrBadWorkloadName = errors.New("workload names must be lower case letters, numbers and '-'")
 
+var workloadNameRE = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,61}[a-z0-9])?$`)
+
+// KeyGen manages a set of independent named workloads.  The default workload
+// always exists.
 type KeyGen struct {
-	mu             sync.Mutex
-	config         Config
-	history        []History
-	nextHistoryID  int
-	nextWorkloadID int
-	cancelFunc     context.CancelFunc
+	mu        sync.Mutex
+	workloads map[string]*runner
+	def       *runner
+	exitFunc  func(code int)
+
+	// shutdown is closed when the process starts shutting down so that
+	// streams end.
+	shutdownOnce sync.Once
+	shutdown     chan struct{}
 }
 
 func New() *KeyGen {
 	kg := &KeyGen{
-		history: []History{},
+		workloads: map[string]*runner{},
+		exitFunc:  os.Exit,
+		shutdown:  make(chan struct{}),
 	}
+	kg.def = newRunner(DefaultWorkload, kg.exit)
+	kg.workloads[DefaultWorkload] = kg.def
 	return kg
 }
 
+// SetExitFunc sets the function called to end the process when a workload
+// with ExitOnComplete completes.  By default this is os.Exit.
+func (kg *KeyGen) SetExitFunc(f func(code int)) {
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	kg.exitFunc = f
+}
+
+// Shutdown ends any open streams.  Call it when the HTTP servers start
+// shutting down, as streams never finish on their own and would hold up a
+// graceful shutdown.
+func (kg *KeyGen) Shutdown() {
+	kg.shutdownOnce.Do(func() {
+		close(kg.shutdown)
+	})
+}
+
+func (kg *KeyGen) exit(code int) {
+	kg.mu.Lock()
+	exitFunc := kg.exitFunc
+	kg.mu.Unlock()
+	exitFunc(code)
+}
+
 func (kg *KeyGen) AddRoutes(router *httprouter.Router, base string) {
+	// The default workload
 	router.GET(base, kg.APIGet)
 	router.PUT(base, kg.APIPut)
+	router.GET(base+"/stream", kg.APIStream)
+	router.POST(base+"/start", kg.APIStart)
+	router.POST(base+"/stop", kg.APIStop)
+	router.POST(base+"/pause", kg.APIPause)
+	router.POST(base+"/resume", kg.APIResume)
+
+	// Keys on demand, independent of the workloads
+	router.POST(base+"/generate", kg.APIGenerate)
+
+	// All workloads by name
+	router.GET(base+"/workloads", kg.APIListWorkloads)
+	router.GET(base+"/workloads/:name", kg.APIGet)
+	router.PUT(base+"/workloads/:name", kg.APIPut)
+	router.DELETE(base+"/workloads/:name", kg.APIDeleteWorkload)
+	router.GET(base+"/workloads/:name/stream", kg.APIStream)
+	router.POST(base+"/workloads/:name/start", kg.APIStart)
+	router.POST(base+"/workloads/:name/stop", kg.APIStop)
+	router.POST(base+"/workloads/:name/pause", kg.APIPause)
+	router.POST(base+"/workloads/:name/resume", kg.APIResume)
 }
 
+// LoadConfig sets the config of the default workload.
+func (kg *KeyGen) LoadConfig(c Config) {
+	kg.def.loadConfig(c)
+}
+
+// Restart restarts the default workload.
 func (kg *KeyGen) Restart() {
-	kg.mu.Lock()
-	defer kg.mu.Unlock()
+	kg.def.restart()
+}
 
-	// Cancel currently running workload
-	if kg.cancelFunc != nil {
-		kg.cancelFunc()
-		kg.cancelFunc = nil
+// PutWorkload creates the named workload, or replaces its config if it
+// exists.
+func (kg *KeyGen) PutWorkload(name string, c Config) error {
+	if !workloadNameRE.MatchString(name) {
+		return ErrBadWorkloadName
 	}
 
-	if kg.config.Enable {
-		var ctx context.Context
-		ctx, kg.cancelFunc = context.WithCancel(context.Background())
-
-		if len(kg.config.MemQQueue) > 0 && len(kg.config.MemQServer) > 0 {
-			w := newMemQWorker(ctx, kg.config, kg.WorkloadOutput)
-			go w.startWork()
-		} else {
-			w := workload{
-				id:  kg.nextWorkloadID,
-				c:   kg.config,
-				ctx: ctx,
-				out: kg.WorkloadOutput,
-			}
-			kg.nextWorkloadID++
-			go w.startWork()
-		}
+	kg.mu.Lock()
+	rn, ok := kg.workloads[name]
+	if !ok {
+		rn = newRunner(name, kg.exit)
+		kg.workloads[name] = rn
 	}
+	kg.mu.Unlock()
+
+	rn.loadConfig(c)
+	return nil
 }
 
-func (kg *KeyGen) WorkloadOutput(s string) {
+// DeleteWorkload stops the named workload and forgets about it.
+func (kg *KeyGen) DeleteWorkload(name string) error {
+	if name == DefaultWorkload {
+		return ErrDeleteDefault
+	}
+
 	kg.mu.Lock()
-	defer kg.mu.Unlock()
+	rn, ok := kg.workloads[name]
+	if !ok {
+		kg.mu.Unlock()
+		return ErrWorkloadNotExist
+	}
+	delete(kg.workloads, name)
+	kg.mu.Unlock()
 
-	log.Print(s)
+	rn.stop()
+	rn.wait()
+	deleteWorkloadMetrics(name)
+	return nil
+}
 
-	kg.history = append(kg.history, History{ID: kg.nextHistoryID, Data: s})
-	if len(kg.history) > maxHistory {
-		kg.history = kg.history[len(kg.history)-maxHistory:]
+// Workloads returns the status of all workloads, sorted by name.
+func (kg *KeyGen) Workloads() []*KeyGenStatus {
+	kg.mu.Lock()
+	runners := make([]*runner, 0, len(kg.workloads))
+	for _, rn := range kg.workloads {
+		runners = append(runners, rn)
 	}
+	kg.mu.Unlock()
 
-	kg.nextHistoryID++
+	sort.Slice(runners, func(i, j int) bool { return runners[i].name < runners[j].name })
+	statuses := make([]*KeyGenStatus, len(runners))
+	for i, rn := range runners {
+		statuses[i] = rn.status()
+	}
+	return statuses
 }
 
-func generateKey() string {
-	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return fmt.Sprintf("Error generating key: %v", err)
+// workload returns the named workload.  An empty name is the default workload.
+func (kg *KeyGen) workload(name string) (*runner, error) {
+	if len(name) == 0 {
+		return kg.def, nil
 	}
 
-	pub, err := ssh.NewPublicKey(&privateKey.PublicKey)
-	if err != nil {
-		return fmt.Sprintf("Error generating ssh key: %v", err)
+	kg.mu.Lock()
+	defer kg.mu.Unlock()
+	rn, ok := kg.workloads[name]
+	if !ok {
+		return nil, ErrWorkloadNotExist
 	}
-
-	return ssh.FingerprintSHA256(pub)
+	return rn, nil
 }
@@ -0,0 +1,162 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// DefaultKind is used when no kind of work is configured.
+const DefaultKind = "keygen"
+
+// Kind is a type of synthetic work.  Each call to Do performs a single item
+// of work.  Kinds work with every mode: local workloads, MemQ work items and
+// (by describing the work to do) producers.
+type Kind interface {
+	// Do performs one item of work.  Long running items should stop early if
+	// ctx is done.
+	Do(ctx context.Context, p ItemParams) (*ItemResult, error)
+}
+
+// ItemParams are the parameters for a single item of work.  Each kind only
+// uses those that make sense for it.
+type ItemParams struct {
+	// Algorithm is the type of key to generate.
+	Algorithm string
+
+	// Size is the number of bytes to hash, allocate or write.
+	Size int
+
+	// Dir is where files are written.
+	Dir string
+
+	// If OutputDir is set, generated keys are written there (see writeKey).
+	// Private keys are only written if OutputPrivateKeys is set.
+	OutputDir         string
+	OutputPrivateKeys bool
+}
+
+// ItemResult describes a finished item of work.
+type ItemResult struct {
+	// Desc is a one line description for the workload history.
+	Desc string
+
+	// Duration is filled in by doItem.
+	Duration time.Duration
+
+	// KeyDuration is how long generating the key took, for keys.  Unlike
+	// Duration it doesn't include writing the key out.
+	KeyDuration time.Duration
+
+	// Bytes is the amount of data processed, if that makes sense for the
+	// kind.
+	Bytes int64
+
+	// Fingerprint is set for keys.
+	Fingerprint string
+
+	// Stats are measurements specific to the kind, such as throughput.
+	Stats map[string]float64
+}
+
+// ItemStats summarizes the items done by a workload.
+type ItemStats struct {
+	Bytes       int64   `json:"bytes"`
+	MinSeconds  float64 `json:"minSeconds"`
+	MeanSeconds float64 `json:"meanSeconds"`
+	MaxSeconds  float64 `json:"maxSeconds"`
+
+	// Last are the kind specific stats of the most recent item.
+	Last map[string]float64 `json:"last,omitempty"`
+}
+
+// kinds are the built in kinds of work by name.
+var kinds = map[string]Kind{
+	"keygen": keygenKind{},
+	"sha256": sha256Kind{},
+	"memory": memoryKind{},
+	"disk":   diskKind{},
+}
+
+// Kinds returns the names of all kinds of work, sorted.
+func Kinds() []string {
+	names := make([]string, 0, len(kinds))
+	for name := range kinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkKind(kind string) error {
+	if _, ok := kinds[kind]; !ok {
+		return fmt.Errorf("unknown kind %q, must be one of %v", kind, Kinds())
+	}
+	return nil
+}
+
+// doItem does one item of work of the given kind and records metrics for it.
+// A result is always returned, describing the error if there is one.
+func doItem(ctx context.Context, kind string, p ItemParams, mode string) (*ItemResult, error) {
+	k, ok := kinds[kind]
+	if !ok {
+		err := checkKind(kind)
+		return &ItemResult{Desc: fmt.Sprintf("Error: %v", err)}, err
+	}
+
+	start := time.Now()
+	r, err := k.Do(ctx, p)
+	d := time.Since(start)
+	if err != nil {
+		return &ItemResult{Desc: fmt.Sprintf("Error: %v", err), Duration: d}, err
+	}
+	r.Duration = d
+
+	recordItem(kind, mode, r)
+	if kind == "keygen" {
+		recordKey(p.Algorithm, mode, r.KeyDuration)
+	}
+	return r, nil
+}
+
+// keygenKind generates a key pair.
+type keygenKind struct{}
+
+func (keygenKind) Do(ctx context.Context, p ItemParams) (*ItemResult, error) {
+	start := time.Now()
+	k, err := generateKey(p.Algorithm)
+	d := time.Since(start)
+	if err != nil {
+		return nil, err
+	}
+	desc := k.describe()
+	if len(p.OutputDir) > 0 {
+		name, err := writeKey(p.OutputDir, p.OutputPrivateKeys, k)
+		if err != nil {
+			return nil, fmt.Errorf("error writing key: %v", err)
+		}
+		desc = fmt.Sprintf("%s (%s)", desc, name)
+	}
+	return &ItemResult{
+		Desc:        desc,
+		KeyDuration: d,
+		Fingerprint: k.fingerprint(),
+	}, nil
+}
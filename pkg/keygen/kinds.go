@@ -0,0 +1,179 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+)
+
+// chunkSize is the unit of work for the kinds that process a buffer.  They
+// check for cancellation between chunks.
+const chunkSize = 1 << 20
+
+// throughput returns bytes per second in MB/s.
+func throughput(bytes int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(bytes) / 1e6 / d.Seconds()
+}
+
+// sha256Kind hashes a buffer.  This is CPU bound like keygen but the cost of
+// each item scales with the size.
+type sha256Kind struct{}
+
+func (sha256Kind) Do(ctx context.Context, p ItemParams) (*ItemResult, error) {
+	chunk := make([]byte, chunkSize)
+	for i := range chunk {
+		chunk[i] = byte(i)
+	}
+
+	start := time.Now()
+	h := sha256.New()
+	var n int64
+	for n < int64(p.Size) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b := chunk
+		if left := int64(p.Size) - n; left < int64(len(b)) {
+			b = b[:left]
+		}
+		h.Write(b)
+		n += int64(len(b))
+	}
+	mbps := throughput(n, time.Since(start))
+
+	return &ItemResult{
+		Desc:  fmt.Sprintf("sha256 %s %x (%.1f MB/s)", humanize.IBytes(uint64(n)), h.Sum(nil)[:8], mbps),
+		Bytes: n,
+		Stats: map[string]float64{"mbPerSecond": mbps},
+	}, nil
+}
+
+// memoryKind allocates a buffer a chunk at a time, touches every page and then
+// lets it go for the garbage collector.  Running this in a loop churns the
+// heap.
+type memoryKind struct{}
+
+func (memoryKind) Do(ctx context.Context, p ItemParams) (*ItemResult, error) {
+	start := time.Now()
+	chunks := [][]byte{}
+	var n int64
+	for n < int64(p.Size) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		size := chunkSize
+		if left := int64(p.Size) - n; left < int64(size) {
+			size = int(left)
+		}
+		b := make([]byte, size)
+		for i := 0; i < len(b); i += os.Getpagesize() {
+			b[i] = 1
+		}
+		chunks = append(chunks, b)
+		n += int64(size)
+	}
+	mbps := throughput(n, time.Since(start))
+
+	return &ItemResult{
+		Desc:  fmt.Sprintf("memory %s in %d chunks (%.1f MB/s)", humanize.IBytes(uint64(n)), len(chunks), mbps),
+		Bytes: n,
+		Stats: map[string]float64{
+			"chunks":      float64(len(chunks)),
+			"mbPerSecond": mbps,
+		},
+	}, nil
+}
+
+// diskKind writes a file, syncs it to disk, reads it back and removes it.
+type diskKind struct{}
+
+func (diskKind) Do(ctx context.Context, p ItemParams) (*ItemResult, error) {
+	f, err := ioutil.TempFile(p.Dir, "kuard-disk-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	chunk := make([]byte, chunkSize)
+	for i := range chunk {
+		chunk[i] = byte(i)
+	}
+
+	start := time.Now()
+	var n int64
+	for n < int64(p.Size) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		b := chunk
+		if left := int64(p.Size) - n; left < int64(len(b)) {
+			b = b[:left]
+		}
+		if _, err := f.Write(b); err != nil {
+			return nil, err
+		}
+		n += int64(len(b))
+	}
+	if err := f.Sync(); err != nil {
+		return nil, err
+	}
+	write := throughput(n, time.Since(start))
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	start = time.Now()
+	var read int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		m, err := f.Read(chunk)
+		read += int64(m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if read != n {
+		return nil, fmt.Errorf("read %d bytes back, wrote %d", read, n)
+	}
+	readMBps := throughput(read, time.Since(start))
+
+	return &ItemResult{
+		Desc:  fmt.Sprintf("disk %s (write %.1f MB/s, read %.1f MB/s)", humanize.IBytes(uint64(n)), write, readMBps),
+		Bytes: n,
+		Stats: map[string]float64{
+			"writeMBPerSecond": write,
+			"readMBPerSecond":  readMBps,
+		},
+	}, nil
+}
@@ -18,25 +18,119 @@ package keygen
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/kubernetes-up-and-running/kuard/pkg/memq"
 	"github.com/kubernetes-up-and-running/kuard/pkg/memq/client"
 )
 
+// maxItemCount limits how many keys a single work item can ask for.
+const maxItemCount = 1000
+
+// WorkItem is the body of a MemQ message describing a job.  Fields that aren't
+// set fall back to the workload config.  Messages that aren't JSON objects are
+// treated as an empty WorkItem.
+type WorkItem struct {
+	Kind      string `json:"kind"`
+	Algorithm string `json:"algorithm"`
+	Count     int    `json:"count"`
+	Label     string `json:"label"`
+}
+
+// WorkResult is published to the results queue for every work item processed.
+type WorkResult struct {
+	Kind         string   `json:"kind"`
+	Queue        string   `json:"queue"`
+	MessageID    string   `json:"messageId"`
+	Label        string   `json:"label"`
+	ItemKind     string   `json:"itemKind"`
+	Algorithm    string   `json:"algorithm"`
+	Fingerprints []string `json:"fingerprints"`
+	Bytes        int64    `json:"bytes"`
+	Duration     float64  `json:"durationSeconds"`
+	Hostname     string   `json:"hostname"`
+	Worker       string   `json:"worker"`
+	Error        string   `json:"error,omitempty"`
+}
+
+// memQQueue is one of the queues a memQWorker consumes from.
+type memQQueue struct {
+	name   string
+	weight int
+}
+
+// parseQueues parses a comma separated list of queues with optional weights,
+// such as "high=3,low".  The default weight is 1.
+func parseQueues(s string) ([]memQQueue, error) {
+	queues := []memQQueue{}
+	for _, q := range strings.Split(s, ",") {
+		q = strings.TrimSpace(q)
+		name, weight := q, 1
+		if i := strings.Index(q, "="); i >= 0 {
+			name = strings.TrimSpace(q[:i])
+			w, err := strconv.Atoi(strings.TrimSpace(q[i+1:]))
+			if err != nil || w < 1 {
+				return nil, fmt.Errorf("bad weight for queue %q, must be a positive integer", name)
+			}
+			weight = w
+		}
+		if len(name) == 0 {
+			return nil, fmt.Errorf("bad queue list %q", s)
+		}
+		queues = append(queues, memQQueue{name: name, weight: weight})
+	}
+	return queues, nil
+}
+
+// memQWorker pulls work items off of one or more MemQ queues and does the work
+// described by each (see WorkItem).  There are Parallelism workers pulling
+// from the queues concurrently.  If a results queue is configured, a
+// WorkResult is published there for each item.
 type memQWorker struct {
+	// failures counts errors against the error budget.  It is first in the
+	// struct for 64-bit alignment of atomic access, as with workload.claimed.
+	failures int64
+
+	name string
+	id   int
 	c    Config
 	ctx  context.Context
+	cpu  *cpuShaper
+	rate *rateLimiter
+	p    *progress
 	out  func(string)
+	exit func(code int)
 	memq memqclient.Client
+
+	queues []memQQueue
+
+	// missing holds the queues found not to exist so that each is only logged
+	// once.
+	missing sync.Map
+
+	// cancel stops all of the workers when the error budget is used up.
+	cancel context.CancelFunc
+	failed int32
 }
 
-func newMemQWorker(ctx context.Context, c Config, out func(string)) *memQWorker {
+func newMemQWorker(ctx context.Context, name string, id int, c Config, cpu *cpuShaper, rate *rateLimiter, p *progress, out func(string), exit func(code int)) *memQWorker {
 	w := &memQWorker{
-		c:   c,
-		ctx: ctx,
-		out: out,
+		name: name,
+		id:   id,
+		c:    c,
+		ctx:  ctx,
+		cpu:  cpu,
+		rate: rate,
+		p:    p,
+		out:  out,
+		exit: exit,
 		memq: memqclient.Client{
 			BaseServerURL: c.MemQServer,
 		},
@@ -45,51 +139,295 @@ func newMemQWorker(ctx context.Context, c Config, out func(string)) *memQWorker
 }
 
 func (w *memQWorker) startWork() {
-	w.log("MemQ Worker starting")
-	for !w.isDone() {
-		m, err := w.memq.Dequeue(w.c.MemQQueue)
+	n := w.c.parallelism()
+	w.logf("(ID %d) MemQ Worker starting: %s, %d worker(s)", w.id, w.c.describeWork(), n)
+	if err := w.c.validate(); err != nil {
+		w.logf("(ID %d) MemQ Worker can't start: %v", w.id, err)
+		w.p.finish(StateFailed, err)
+		return
+	}
+	w.queues, _ = parseQueues(w.c.MemQQueue)
+	w.ctx, w.cancel = context.WithCancel(w.ctx)
+	defer w.cancel()
+
+	// MemQ workers run until the queue is empty so there is no fixed budget.
+	remainingItems.WithLabelValues(w.name).Set(-1)
+	remainingSeconds.WithLabelValues(w.name).Set(-1)
+
+	if len(w.c.MemQResultsQueue) > 0 {
+		if err := w.memq.EnsureQueue(w.c.MemQResultsQueue); err != nil {
+			w.logf("(ID %d) Can't create results queue %s: %v", w.id, w.c.MemQResultsQueue, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			activeWorkers.Inc()
+			defer activeWorkers.Dec()
+			w.work(worker)
+		}(i)
+	}
+	wg.Wait()
+
+	if atomic.LoadInt32(&w.failed) != 0 {
+		w.giveUp()
+		return
+	}
+	w.done(w.ctx.Err() != nil)
+}
+
+// work processes items until canceled or, if ExitOnComplete is set, the queue
+// is empty.
+func (w *memQWorker) work(worker int) {
+	t := w.cpu.newThrottle()
+	b := newBackoff()
+	for w.p.wait(w.ctx) {
+		queue, m, err := w.dequeue()
 		if err != nil {
-			w.logf("Error talking to server: %v. Retrying after 1s.", err)
-			time.Sleep(time.Second)
+			memqDequeueErrors.Inc()
+			w.fail()
+			d := b.next()
+			w.logf("(ID %d.%d) Error talking to server: %v. Retrying after %v.", w.id, worker, err, d.Round(time.Millisecond))
+			sleep(w.ctx, d)
 			continue
 		}
+		b.reset()
 
 		if m == nil {
-			// Queue is empty.  Exit if necessary. Otherwise sleep.
+			memqEmptyPolls.Inc()
+			// Queue is empty.  Stop if necessary. Otherwise sleep.
 			if w.c.ExitOnComplete {
-				os.Exit(w.c.ExitCode)
+				return
 			}
-			w.logf("Queue is empty. Retrying after 1s.")
-			time.Sleep(time.Second)
+			w.logf("(ID %d.%d) Queue is empty. Retrying after 1s.", w.id, worker)
+			sleep(w.ctx, time.Second)
 			continue
 		}
 
-		w.itemDone(generateKey())
+		w.process(worker, t, queue, m)
 	}
 }
 
-func (w *memQWorker) isDone() bool {
-	select {
-	case <-w.ctx.Done():
-		w.log("MemQ Worker shutting down")
-		return true
-	default:
+// dequeue takes the next message off of the queues, trying them in the order
+// picked by the strategy.  A nil message means all of the queues are empty.
+// Queues that don't exist are treated as empty.  An error is only returned if
+// no queue had a message and at least one of them failed.
+func (w *memQWorker) dequeue() (string, *memq.Message, error) {
+	var lastErr error
+	for _, queue := range w.order() {
+		m, err := w.memq.Dequeue(queue)
+		if err != nil {
+			if !w.queueMissing(queue) {
+				lastErr = fmt.Errorf("queue %s: %v", queue, err)
+			}
+			continue
+		}
+		if m != nil {
+			return queue, m, nil
+		}
 	}
-	return false
+	return "", nil, lastErr
 }
 
-func (w memQWorker) itemDone(desc string) {
+// queueMissing reports whether queue doesn't exist on the server.  The server
+// answers a missing queue with the same status as any other bad request, so
+// this asks for the queue's stats.  A queue may be created later so it isn't
+// an error, and polling it doesn't count against the error budget.
+func (w *memQWorker) queueMissing(queue string) bool {
+	s, err := w.memq.Stats(queue)
+	if err != nil || len(s.Queues) > 0 {
+		return false
+	}
+	if _, logged := w.missing.LoadOrStore(queue, true); !logged {
+		w.logf("(ID %d) Queue %s does not exist, treating it as empty", w.id, queue)
+	}
+	return true
+}
+
+// order returns the queue names in the order to try them for the next
+// message.
+func (w *memQWorker) order() []string {
+	names := make([]string, 0, len(w.queues))
+	if w.c.MemQStrategy != StrategyWeighted {
+		for _, q := range w.queues {
+			names = append(names, q.name)
+		}
+		return names
+	}
 
+	// Pick queues at random by weight until none are left.
+	left := append([]memQQueue{}, w.queues...)
+	for len(left) > 0 {
+		total := 0
+		for _, q := range left {
+			total += q.weight
+		}
+		n := int(randomInt63n(int64(total)))
+		i := 0
+		for ; n >= left[i].weight; i++ {
+			n -= left[i].weight
+		}
+		names = append(names, left[i].name)
+		left = append(left[:i], left[i+1:]...)
+	}
+	return names
+}
+
+// fail counts an error against the error budget.  Once the budget is used up
+// all of the workers are stopped.
+func (w *memQWorker) fail() {
+	n := atomic.AddInt64(&w.failures, 1)
+	if w.c.MemQErrorBudget > 0 && n >= int64(w.c.MemQErrorBudget) {
+		atomic.StoreInt32(&w.failed, 1)
+		w.cancel()
+	}
+}
+
+// giveUp fails the workload and ends the process after the error budget is
+// used up.
+func (w *memQWorker) giveUp() {
+	err := fmt.Errorf("error budget of %d used up", w.c.MemQErrorBudget)
+	w.logf("(ID %d) MemQ Worker giving up: %v", w.id, err)
+	w.p.finish(StateFailed, err)
+	w.exit(w.c.memQErrorExitCode())
+}
+
+// process does the work described by m and publishes the result.  Any error
+// stops the item.  If the worker is canceled part way through, m is put back
+// on the queue instead.
+func (w *memQWorker) process(worker int, t *throttle, queue string, m *memq.Message) {
+	item := WorkItem{}
+	body := strings.TrimSpace(m.Body)
+	if strings.HasPrefix(body, "{") {
+		if err := json.Unmarshal([]byte(body), &item); err != nil {
+			w.logf("(ID %d.%d) Could not parse message %s, using defaults: %v", w.id, worker, m.ID, err)
+		}
+	}
+	if len(item.Kind) == 0 {
+		item.Kind = w.c.kind()
+	}
+	if len(item.Algorithm) == 0 {
+		item.Algorithm = w.c.Algorithm
+	}
+	if item.Count < 1 {
+		item.Count = 1
+	}
+	if item.Count > maxItemCount {
+		item.Count = maxItemCount
+	}
+
+	hostname, _ := os.Hostname()
+	r := &WorkResult{
+		Kind:         "keygenResult",
+		Queue:        queue,
+		MessageID:    m.ID,
+		Label:        item.Label,
+		ItemKind:     item.Kind,
+		Algorithm:    item.Algorithm,
+		Fingerprints: []string{},
+		Hostname:     hostname,
+		Worker:       fmt.Sprintf("%d.%d", w.id, worker),
+	}
+
+	start := time.Now()
+	done := 0
+	params := w.c.itemParams()
+	params.Algorithm = item.Algorithm
+	for i := 0; i < item.Count; i++ {
+		if !w.rate.wait(w.ctx) {
+			w.requeue(worker, queue, m)
+			return
+		}
+		res, err := doItem(w.ctx, item.Kind, params, ModeMemQ)
+		t.worked(w.ctx, res.Duration)
+		if err != nil && w.ctx.Err() != nil {
+			w.requeue(worker, queue, m)
+			return
+		}
+		w.p.itemDone(res)
+		if err != nil {
+			r.Error = err.Error()
+			w.p.itemFailed()
+			w.fail()
+			break
+		}
+		done++
+		r.Bytes += res.Bytes
+		if len(res.Fingerprint) > 0 {
+			r.Fingerprints = append(r.Fingerprints, res.Fingerprint)
+		}
+	}
+	r.Duration = time.Since(start).Seconds()
+
+	what := item.Algorithm
+	if item.Kind != DefaultKind {
+		what = item.Kind
+	}
+	desc := fmt.Sprintf("%s %d x %s", m.ID, done, what)
+	if len(w.queues) > 1 {
+		desc = fmt.Sprintf("%s/%s", queue, desc)
+	}
+	if len(item.Label) > 0 {
+		desc = fmt.Sprintf("%s (%s)", desc, item.Label)
+	}
+	if len(r.Error) > 0 {
+		desc = fmt.Sprintf("%s, error: %s", desc, r.Error)
+	} else if len(r.Fingerprints) == 1 {
+		desc = fmt.Sprintf("%s %s", desc, r.Fingerprints[0])
+	}
+	w.itemDone(worker, desc)
+
+	w.publish(worker, r)
+}
+
+// requeue puts back a message whose work was canceled so that it isn't lost.
+// It goes on the tail of the queue with a new ID.
+func (w *memQWorker) requeue(worker int, queue string, m *memq.Message) {
+	if _, err := w.memq.Enqueue(queue, m.Body); err != nil {
+		w.logf("(ID %d.%d) Item %s canceled and could not be returned to %s: %v", w.id, worker, m.ID, queue, err)
+		return
+	}
+	w.logf("(ID %d.%d) Item %s canceled, returned to %s", w.id, worker, m.ID, queue)
+}
+
+// publish sends r to the results queue, if there is one.
+func (w *memQWorker) publish(worker int, r *WorkResult) {
+	if len(w.c.MemQResultsQueue) == 0 {
+		return
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		w.logf("(ID %d.%d) Could not encode result: %v", w.id, worker, err)
+		return
+	}
+	if _, err := w.memq.Enqueue(w.c.MemQResultsQueue, string(b)); err != nil {
+		w.logf("(ID %d.%d) Could not publish result for %s: %v", w.id, worker, r.MessageID, err)
+	}
+}
+
+func (w *memQWorker) itemDone(worker int, desc string) {
 	if len(desc) > 0 {
 		desc = ": " + desc
 	}
 
-	w.logf("Item done%s", desc)
+	w.logf("(ID %d.%d) Item done%s", w.id, worker, desc)
 }
 
-func (w *memQWorker) done() {
-
+func (w *memQWorker) done(canceled bool) {
+	w.logf("(ID %d) MemQ Worker shutting down", w.id)
+	if canceled {
+		return
+	}
+	w.p.finish(StateCompleted, nil)
+	if w.c.ExitOnComplete {
+		w.exit(w.c.ExitCode)
+	}
 }
+
 func (w *memQWorker) log(s string) {
 	w.out(s)
 }
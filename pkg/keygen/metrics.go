@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func init() {
+	prometheus.MustRegister(
+		itemsDone,
+		itemDuration,
+		itemBytes,
+		keysGenerated,
+		generationDuration,
+		activeWorkers,
+		memqDequeueErrors,
+		memqEmptyPolls,
+		memqEnqueued,
+		memqEnqueueErrors,
+		remainingItems,
+		remainingSeconds,
+	)
+}
+
+var itemsDone = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "keygen_items_total",
+	Help: "Number of items of work done, by kind",
+}, []string{"kind", "mode"})
+
+var itemDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "keygen_item_duration_seconds",
+	Help:    "Time to do a single item of work",
+	Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+}, []string{"kind"})
+
+var itemBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "keygen_item_bytes_total",
+	Help: "Bytes hashed, allocated or written by items of work, by kind",
+}, []string{"kind"})
+
+var keysGenerated = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "keygen_keys_generated_total",
+	Help: "Number of keys generated by the keygen workload",
+}, []string{"algorithm", "mode"})
+
+var generationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "keygen_generation_duration_seconds",
+	Help:    "Time to generate a single key",
+	Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+}, []string{"algorithm"})
+
+var activeWorkers = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "keygen_active_workers",
+	Help: "Number of keygen workers currently running",
+})
+
+var memqDequeueErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "keygen_memq_dequeue_errors_total",
+	Help: "Number of errors pulling work items from MemQ",
+})
+
+var memqEmptyPolls = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "keygen_memq_empty_polls_total",
+	Help: "Number of times a keygen worker found the MemQ queue empty",
+})
+
+var memqEnqueued = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "keygen_memq_enqueued_total",
+	Help: "Number of work items enqueued to MemQ by the keygen producer",
+})
+
+var memqEnqueueErrors = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "keygen_memq_enqueue_errors_total",
+	Help: "Number of errors enqueuing work items to MemQ",
+})
+
+var remainingItems = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "keygen_remaining_items",
+	Help: "Number of keys left to generate before NumToGen is reached. -1 if there is no limit",
+}, []string{"workload"})
+
+var remainingSeconds = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "keygen_remaining_seconds",
+	Help: "Seconds left before TimeToRun is reached. -1 if there is no limit",
+}, []string{"workload"})
+
+func recordItem(kind, mode string, r *ItemResult) {
+	itemsDone.WithLabelValues(kind, mode).Inc()
+	itemDuration.WithLabelValues(kind).Observe(r.Duration.Seconds())
+	if r.Bytes > 0 {
+		itemBytes.WithLabelValues(kind).Add(float64(r.Bytes))
+	}
+}
+
+func recordKey(alg, mode string, d time.Duration) {
+	keysGenerated.WithLabelValues(alg, mode).Inc()
+	generationDuration.WithLabelValues(alg).Observe(d.Seconds())
+}
+
+// deleteWorkloadMetrics removes the per workload metrics for a deleted
+// workload.
+func deleteWorkloadMetrics(name string) {
+	remainingItems.DeleteLabelValues(name)
+	remainingSeconds.DeleteLabelValues(name)
+}
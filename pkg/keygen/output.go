@@ -0,0 +1,164 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/ssh"
+)
+
+// ManifestFile is the name of the file in the output directory that lists
+// every key written there.
+const ManifestFile = "manifest.txt"
+
+// manifestMu serializes appends to the manifest between workers.
+var manifestMu sync.Mutex
+
+// oidEd25519 is the algorithm identifier for Ed25519 keys from RFC 8410.  The
+// x509 package doesn't know about Ed25519 keys so they are encoded by hand.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// name returns a short name for the key that is unique enough to use as a
+// file name.
+func (k *key) name() string {
+	sum := sha256.Sum256(k.public.Marshal())
+	return "key-" + hex.EncodeToString(sum[:8])
+}
+
+// authorizedKey returns the public key in the OpenSSH authorized_keys format.
+func (k *key) authorizedKey() []byte {
+	return ssh.MarshalAuthorizedKey(k.public)
+}
+
+// publicPEM returns the public key as a PEM encoded PKIX structure.
+func (k *key) publicPEM() ([]byte, error) {
+	var der []byte
+	var err error
+	switch priv := k.private.(type) {
+	case *rsa.PrivateKey:
+		der, err = x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	case *ecdsa.PrivateKey:
+		der, err = x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	case ed25519.PrivateKey:
+		der, err = asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+			PublicKey: asn1.BitString{
+				Bytes:     priv.Public().(ed25519.PublicKey),
+				BitLength: 8 * ed25519.PublicKeySize,
+			},
+		})
+	default:
+		err = fmt.Errorf("unsupported key type %T", k.private)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// privatePEM returns the private key as a PEM encoded PKCS #8 structure.
+func (k *key) privatePEM() ([]byte, error) {
+	var der []byte
+	var err error
+	switch priv := k.private.(type) {
+	case *rsa.PrivateKey, *ecdsa.PrivateKey:
+		der, err = x509.MarshalPKCS8PrivateKey(priv)
+	case ed25519.PrivateKey:
+		// The private key is the seed, wrapped in an OCTET STRING.
+		var seed []byte
+		seed, err = asn1.Marshal(priv.Seed())
+		if err != nil {
+			return nil, err
+		}
+		der, err = asn1.Marshal(struct {
+			Version    int
+			Algorithm  pkix.AlgorithmIdentifier
+			PrivateKey []byte
+		}{
+			Algorithm:  pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+			PrivateKey: seed,
+		})
+	default:
+		err = fmt.Errorf("unsupported key type %T", k.private)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// writeKey writes k to dir and adds it to the manifest there.  The public key
+// is written as NAME.pub in the authorized_keys format and as NAME.pem.  If
+// private is set, the private key is written to NAME.key.  It returns NAME.
+func writeKey(dir string, private bool, k *key) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+
+	name := k.name()
+	base := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(base+".pub", k.authorizedKey(), 0644); err != nil {
+		return "", err
+	}
+	pub, err := k.publicPEM()
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(base+".pem", pub, 0644); err != nil {
+		return "", err
+	}
+	if private {
+		priv, err := k.privatePEM()
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(base+".key", priv, 0600); err != nil {
+			return "", err
+		}
+	}
+
+	// Each line is the fingerprint, the algorithm and the name of the files.
+	// The fingerprint matches the output of "ssh-keygen -l -f NAME.pub".
+	manifestMu.Lock()
+	defer manifestMu.Unlock()
+	f, err := os.OpenFile(filepath.Join(dir, ManifestFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fmt.Fprintf(f, "%s %s %s\n", k.fingerprint(), k.algorithm, name); err != nil {
+		f.Close()
+		return "", err
+	}
+	return name, f.Close()
+}
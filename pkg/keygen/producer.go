@@ -0,0 +1,121 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/kubernetes-up-and-running/kuard/pkg/memq/client"
+)
+
+// producer fills a MemQ queue with work items for memQWorkers to consume.  It
+// shares the limits of a local workload: NumToGen items are enqueued (across
+// Parallelism workers) unless TimeToRun is reached first, and Rate limits how
+// fast they are enqueued.
+type producer struct {
+	workload
+	memq memqclient.Client
+}
+
+func newProducer(w workload) *producer {
+	return &producer{
+		workload: w,
+		memq: memqclient.Client{
+			BaseServerURL: w.c.MemQServer,
+		},
+	}
+}
+
+func (w *producer) startWork() {
+	n := w.c.parallelism()
+	w.logf("(ID %d) Producer starting: %s to %s, %d worker(s)", w.id, w.c.describeWork(), w.c.memQQueue(), n)
+	if err := w.c.validate(); err != nil {
+		w.logf("(ID %d) Producer can't start: %v", w.id, err)
+		w.p.finish(StateFailed, err)
+		return
+	}
+	w.updateBudget()
+
+	if err := w.memq.EnsureQueue(w.c.memQQueue()); err != nil {
+		w.logf("(ID %d) Can't create queue %s: %v", w.id, w.c.memQQueue(), err)
+	}
+
+	hostname, _ := os.Hostname()
+	b, err := json.Marshal(&WorkItem{
+		Kind:      w.c.kind(),
+		Algorithm: w.c.Algorithm,
+		Count:     1,
+		Label:     fmt.Sprintf("%s/%s", hostname, w.name),
+	})
+	if err != nil {
+		w.logf("(ID %d) Producer can't start: %v", w.id, err)
+		w.p.finish(StateFailed, err)
+		return
+	}
+	body := string(b)
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			activeWorkers.Inc()
+			defer activeWorkers.Dec()
+
+			for w.claim() {
+				start := time.Now()
+				id, ok := w.enqueue(worker, body)
+				if !ok {
+					return
+				}
+				memqEnqueued.Inc()
+				w.itemDone(worker, &ItemResult{
+					Desc:     "Enqueued " + id,
+					Duration: time.Since(start),
+				})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	remainingItems.WithLabelValues(w.name).Set(0)
+	remainingSeconds.WithLabelValues(w.name).Set(0)
+	w.done(w.ctx.Err() != nil)
+}
+
+// enqueue adds a work item to the queue, retrying until it succeeds.  It
+// returns the message ID or false if the producer was canceled.
+func (w *producer) enqueue(worker int, body string) (string, bool) {
+	b := newBackoff()
+	for {
+		m, err := w.memq.Enqueue(w.c.memQQueue(), body)
+		if err == nil {
+			return m.ID, true
+		}
+
+		memqEnqueueErrors.Inc()
+		d := b.next()
+		w.logf("(ID %d.%d) Error talking to server: %v. Retrying after %v.", w.id, worker, err, d.Round(time.Millisecond))
+		if !sleep(w.ctx, d) {
+			return "", false
+		}
+	}
+}
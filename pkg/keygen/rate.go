@@ -0,0 +1,122 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateStatus reports the configured rate and the rate actually achieved since
+// it was last set.  Both are in keys per Unit.
+type RateStatus struct {
+	Target   float64 `json:"target"`
+	Achieved float64 `json:"achieved"`
+	Unit     string  `json:"unit"`
+}
+
+// rateLimiter is a token bucket shared by all of the workers in a workload.
+// The bucket holds at most one token so keys are spread evenly rather than
+// generated in bursts.  The rate can be changed while the workload is running.
+type rateLimiter struct {
+	mu     sync.Mutex
+	status RateStatus
+	rate   float64 // tokens per second, 0 for unlimited
+	tokens float64
+	last   time.Time
+
+	// taken is the number of tokens handed out since the rate was set.  Tokens
+	// are counted once the wait for them is over.
+	taken int64
+	since time.Time
+}
+
+func newRateLimiter(c Config) *rateLimiter {
+	l := &rateLimiter{}
+	l.set(c)
+	return l
+}
+
+// set changes the rate, refills the bucket and resets the measurements.
+func (l *rateLimiter) set(c Config) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.status = RateStatus{
+		Target: c.Rate,
+		Unit:   c.rateUnit(),
+	}
+	l.rate = c.ratePerSecond()
+	l.tokens = 1
+	l.last = time.Now()
+	l.taken = 0
+	l.since = l.last
+}
+
+// wait takes a token, sleeping until one is available.  Workers that wait at
+// the same time queue up behind each other.  It returns false if ctx is done.
+func (l *rateLimiter) wait(ctx context.Context) bool {
+	l.mu.Lock()
+	if l.rate <= 0 {
+		l.mu.Unlock()
+		return ctx.Err() == nil
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > 1 {
+		l.tokens = 1
+	}
+	l.last = now
+	l.tokens--
+
+	var d time.Duration
+	if l.tokens < 0 {
+		d = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if d > 0 {
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	l.mu.Lock()
+	l.taken++
+	l.mu.Unlock()
+	return ctx.Err() == nil
+}
+
+func (l *rateLimiter) getStatus() *RateStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	status := l.status
+	if elapsed := time.Since(l.since); elapsed > 0 {
+		status.Achieved = float64(l.taken) / elapsed.Seconds()
+		if status.Unit == RateUnitMinute {
+			status.Achieved *= 60
+		}
+	}
+	return &status
+}
@@ -0,0 +1,301 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// runner controls a single named workload.  It holds the config, the output
+// history and the state of the current run.  Each time the workload is started
+// a new workload (or memQWorker) is created to do the work.
+type runner struct {
+	name string
+
+	// exit is called when a workload with ExitOnComplete completes or a
+	// MemQ worker gives up.
+	exit func(code int)
+
+	mu             sync.Mutex
+	config         Config
+	history        []History
+	historyCh      chan struct{}
+	nextHistoryID  int
+	nextWorkloadID int
+	cancelFunc     context.CancelFunc
+	finished       chan struct{}
+	cpu            *cpuShaper
+	rate           *rateLimiter
+	progress       *progress
+}
+
+func newRunner(name string, exit func(code int)) *runner {
+	return &runner{
+		name:      name,
+		exit:      exit,
+		history:   []History{},
+		historyCh: make(chan struct{}),
+		progress:  &progress{state: StateIdle},
+	}
+}
+
+// loadConfig replaces the config.  The workload is restarted unless the only
+// changes can be applied to the running workload.
+func (rn *runner) loadConfig(c Config) {
+	if len(c.Algorithm) == 0 {
+		c.Algorithm = DefaultAlgorithm
+	}
+
+	rn.mu.Lock()
+	live := rn.progress.active() && c.onlyLiveChanges(rn.config)
+	rn.config = c
+	rn.trimHistory()
+	if live {
+		rn.cpu.set(c)
+		rn.rate.set(c)
+		rn.mu.Unlock()
+		return
+	}
+	rn.mu.Unlock()
+
+	rn.restart()
+}
+
+// restart stops any running workload and starts a new one if the workload is
+// enabled.
+func (rn *runner) restart() {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	rn.lockedStop()
+	if rn.config.Enable {
+		rn.lockedStart()
+	}
+}
+
+// start starts a new workload with the current config.
+func (rn *runner) start() error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if rn.progress.active() {
+		return ErrRunning
+	}
+	rn.config.Enable = true
+	rn.lockedStart()
+	return nil
+}
+
+// stop cancels the running workload.  Its progress is kept until the next one
+// is started.
+func (rn *runner) stop() error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	if !rn.progress.active() {
+		return ErrNotRunning
+	}
+	rn.config.Enable = false
+	rn.lockedStop()
+	return nil
+}
+
+// pause holds the workers before their next item.  Items in progress are
+// finished.
+func (rn *runner) pause() error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return rn.progress.pause()
+}
+
+func (rn *runner) resume() error {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+	return rn.progress.resume()
+}
+
+func (rn *runner) lockedStop() {
+	// Cancel currently running workload
+	if rn.cancelFunc != nil {
+		rn.cancelFunc()
+		rn.cancelFunc = nil
+	}
+	rn.progress.finish(StateIdle, nil)
+}
+
+func (rn *runner) lockedStart() {
+	var ctx context.Context
+	ctx, rn.cancelFunc = context.WithCancel(context.Background())
+	rn.cpu = newCPUShaper(rn.config)
+	rn.rate = newRateLimiter(rn.config)
+	rn.progress = newProgress()
+
+	c, p := rn.config, rn.progress
+	exit := func(code int) {
+		rn.exitProcess(c, p, code)
+	}
+
+	var w interface {
+		startWork()
+	}
+	if rn.config.mode() == ModeMemQ {
+		w = newMemQWorker(ctx, rn.name, rn.nextWorkloadID, rn.config, rn.cpu, rn.rate, rn.progress, rn.workloadOutput, exit)
+	} else {
+		local := workload{
+			name: rn.name,
+			id:   rn.nextWorkloadID,
+			c:    rn.config,
+			ctx:  ctx,
+			cpu:  rn.cpu,
+			rate: rn.rate,
+			p:    rn.progress,
+			out:  rn.workloadOutput,
+			exit: exit,
+		}
+		if rn.config.mode() == ModeProducer {
+			w = newProducer(local)
+		} else {
+			w = &local
+		}
+	}
+
+	// finished is closed once the workers are done.
+	finished := make(chan struct{})
+	rn.finished = finished
+	go func() {
+		w.startWork()
+		close(finished)
+	}()
+	rn.nextWorkloadID++
+}
+
+// wait waits for the workers of the last workload started to finish.
+func (rn *runner) wait() {
+	rn.mu.Lock()
+	finished := rn.finished
+	rn.mu.Unlock()
+
+	if finished != nil {
+		<-finished
+	}
+}
+
+// exitProcess writes a summary of a finished workload to the termination
+// message path and then ends the process with code.
+func (rn *runner) exitProcess(c Config, p *progress, code int) {
+	if len(c.TerminationMessagePath) > 0 {
+		msg := p.summary()
+		if c.Completions > 0 {
+			msg = fmt.Sprintf("[index %d] %s", c.CompletionIndex, msg)
+		}
+		if err := writeTerminationMessage(c.TerminationMessagePath, msg); err != nil {
+			log.Printf("Could not write termination message: %v", err)
+		}
+	}
+
+	rn.exit(code)
+}
+
+// writeTerminationMessage writes msg to path if the file exists.  Outside of
+// Kubernetes there is usually no such file and nothing is written.
+func writeTerminationMessage(path, msg string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(msg + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// status returns the status of the workload.
+func (rn *runner) status() *KeyGenStatus {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	s := &KeyGenStatus{
+		Name:    rn.name,
+		Config:  rn.config,
+		History: rn.history,
+	}
+	rn.progress.status(rn.config, s)
+	if rn.progress.active() {
+		s.CPU = rn.cpu.getStatus()
+		if rn.config.Rate > 0 {
+			s.Rate = rn.rate.getStatus()
+		}
+	}
+	return s
+}
+
+func (rn *runner) workloadOutput(s string) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	// Tag everything with the index so the output of the pods in an Indexed
+	// Job can be told apart once aggregated.
+	if rn.config.Completions > 0 {
+		s = fmt.Sprintf("[index %d] %s", rn.config.CompletionIndex, s)
+	}
+
+	// Workloads other than the default are named in the log so that they can
+	// be told apart.  The history is per workload so it doesn't need this.
+	if rn.name == DefaultWorkload {
+		log.Print(s)
+	} else {
+		log.Printf("[%s] %s", rn.name, s)
+	}
+
+	rn.history = append(rn.history, History{ID: rn.nextHistoryID, Data: s})
+	rn.trimHistory()
+
+	rn.nextHistoryID++
+
+	// Wake up any streams.
+	close(rn.historyCh)
+	rn.historyCh = make(chan struct{})
+}
+
+// trimHistory drops the oldest entries beyond the configured history size.
+func (rn *runner) trimHistory() {
+	if n := rn.config.historySize(); len(rn.history) > n {
+		rn.history = rn.history[len(rn.history)-n:]
+	}
+}
+
+// historySince returns the retained history after ID since along with a
+// channel that is closed when more output is added.
+func (rn *runner) historySince(since int) ([]History, <-chan struct{}) {
+	rn.mu.Lock()
+	defer rn.mu.Unlock()
+
+	i := len(rn.history)
+	for i > 0 && rn.history[i-1].ID > since {
+		i--
+	}
+	history := make([]History, len(rn.history)-i)
+	copy(history, rn.history[i:])
+	return history, rn.historyCh
+}
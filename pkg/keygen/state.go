@@ -0,0 +1,257 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State is the lifecycle state of a workload.
+type State string
+
+const (
+	StateIdle      State = "idle"
+	StateRunning   State = "running"
+	StatePaused    State = "paused"
+	StateCompleted State = "completed"
+	StateFailed    State = "failed"
+)
+
+var ErrRunning = errors.New("workload is already running")
+var ErrNotRunning = errors.New("workload is not running")
+var ErrNotPaused = errors.New("workload is not paused")
+
+// progress tracks the state of a single run of a workload.  It is shared
+// between KeyGen and the workers.  Time spent paused doesn't count against
+// TimeToRun.
+type progress struct {
+	mu        sync.Mutex
+	state     State
+	err       string
+	generated int64
+	errors    int64
+
+	// Stats for the items done.  The count is generated.
+	bytes    int64
+	itemTime time.Duration
+	minTime  time.Duration
+	maxTime  time.Duration
+	last     map[string]float64
+
+	// elapsed is the time spent running before the current running period,
+	// which started at resumed.
+	elapsed time.Duration
+	resumed time.Time
+
+	// resumeCh is closed when a paused workload is resumed.
+	resumeCh chan struct{}
+}
+
+func newProgress() *progress {
+	return &progress{
+		state:   StateRunning,
+		resumed: time.Now(),
+	}
+}
+
+// active returns true if the workload is running or paused.
+func (p *progress) active() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state == StateRunning || p.state == StatePaused
+}
+
+func (p *progress) pause() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != StateRunning {
+		return ErrNotRunning
+	}
+	p.elapsed += time.Since(p.resumed)
+	p.state = StatePaused
+	p.resumeCh = make(chan struct{})
+	return nil
+}
+
+func (p *progress) resume() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != StatePaused {
+		return ErrNotPaused
+	}
+	p.lockedResume()
+	return nil
+}
+
+func (p *progress) lockedResume() {
+	p.state = StateRunning
+	p.resumed = time.Now()
+	close(p.resumeCh)
+	p.resumeCh = nil
+}
+
+// finish moves an active workload to its final state.  Workloads that have
+// already been stopped stay stopped.
+func (p *progress) finish(state State, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != StateRunning && p.state != StatePaused {
+		return
+	}
+	if p.state == StatePaused {
+		p.lockedResume()
+	}
+	p.elapsed += time.Since(p.resumed)
+	p.state = state
+	if err != nil {
+		p.err = err.Error()
+	}
+}
+
+// wait blocks while the workload is paused.  It returns false if ctx is done.
+func (p *progress) wait(ctx context.Context) bool {
+	p.mu.Lock()
+	ch := p.resumeCh
+	p.mu.Unlock()
+
+	if ch != nil {
+		select {
+		case <-ch:
+		case <-ctx.Done():
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// itemDone counts a finished item and returns the new total.
+func (p *progress) itemDone(r *ItemResult) int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.generated++
+	p.bytes += r.Bytes
+	p.itemTime += r.Duration
+	if p.generated == 1 || r.Duration < p.minTime {
+		p.minTime = r.Duration
+	}
+	if r.Duration > p.maxTime {
+		p.maxTime = r.Duration
+	}
+	if r.Stats != nil {
+		p.last = r.Stats
+	}
+	return p.generated
+}
+
+// itemFailed counts an item that finished with an error.  The item should also
+// be counted with itemDone.
+func (p *progress) itemFailed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errors++
+}
+
+func (p *progress) count() int64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.generated
+}
+
+// runTime is how long the workload has spent running, not counting pauses.
+func (p *progress) runTime() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.lockedRunTime()
+}
+
+func (p *progress) lockedRunTime() time.Duration {
+	if p.state == StateRunning {
+		return p.elapsed + time.Since(p.resumed)
+	}
+	return p.elapsed
+}
+
+// summary returns a one line description of the workload for the termination
+// message.
+func (p *progress) summary() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	msg := fmt.Sprintf("keygen workload %s: %d items in %v, %d errors",
+		p.state, p.generated, p.lockedRunTime().Round(time.Millisecond), p.errors)
+	if len(p.err) > 0 {
+		msg = fmt.Sprintf("%s: %s", msg, p.err)
+	}
+	return msg
+}
+
+// status fills in the progress fields of s.
+func (p *progress) status(c Config, s *KeyGenStatus) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	s.State = p.state
+	s.Error = p.err
+	s.Generated = p.generated
+	s.Errors = p.errors
+	if p.generated > 0 {
+		s.Items = &ItemStats{
+			Bytes:       p.bytes,
+			MinSeconds:  p.minTime.Seconds(),
+			MeanSeconds: (p.itemTime / time.Duration(p.generated)).Seconds(),
+			MaxSeconds:  p.maxTime.Seconds(),
+			Last:        p.last,
+		}
+	}
+	elapsed := p.lockedRunTime()
+	s.Elapsed = elapsed.Seconds()
+
+	if p.state != StateRunning && p.state != StatePaused {
+		return
+	}
+
+	// Estimate the time remaining from whichever limit will be hit first.
+	// MemQ workloads run until the queue is empty so there is no estimate.
+	if c.mode() == ModeMemQ {
+		return
+	}
+	var remaining time.Duration = -1
+	if c.NumToGen > 0 && p.generated > 0 {
+		perItem := elapsed / time.Duration(p.generated)
+		remaining = perItem * time.Duration(int64(c.numToGen())-p.generated)
+	}
+	if c.TimeToRun > 0 {
+		left := time.Duration(c.TimeToRun)*time.Second - elapsed
+		if left < 0 {
+			left = 0
+		}
+		if remaining < 0 || left < remaining {
+			remaining = left
+		}
+	}
+	if remaining >= 0 {
+		r := remaining.Seconds()
+		s.Remaining = &r
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package keygen
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+// keepAliveInterval is how often a comment is sent on an idle stream so that
+// proxies don't time it out.
+const keepAliveInterval = 15 * time.Second
+
+// APIStream streams workload output as server-sent events.  The event ID is
+// the History ID.  Retained history after the ID given in the Last-Event-ID
+// header (set by browsers when they reconnect) or the "since" query parameter
+// is sent first.  Without either, all retained history is sent.
+func (kg *KeyGen) APIStream(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+	rn, err := kg.workload(params.ByName("name"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	since := -1
+	s := r.Header.Get("Last-Event-ID")
+	if len(s) == 0 {
+		s = r.URL.Query().Get("since")
+	}
+	if len(s) > 0 {
+		since, err = strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad history ID %q", s), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		history, ch := rn.historySince(since)
+		for _, h := range history {
+			fmt.Fprintf(w, "id: %d\n", h.ID)
+			for _, line := range strings.Split(h.Data, "\n") {
+				fmt.Fprintf(w, "data: %s\n", line)
+			}
+			fmt.Fprint(w, "\n")
+			since = h.ID
+		}
+		f.Flush()
+
+		select {
+		case <-ch:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-kg.shutdown:
+			return
+		}
+	}
+}
@@ -19,80 +19,154 @@ package keygen
 import (
 	"context"
 	"fmt"
-	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	humanize "github.com/dustin/go-humanize"
 )
 
+// workload does items of work locally until NumToGen or TimeToRun is reached.
+// The work is spread across Parallelism workers that share those limits.
 type workload struct {
-	id        int
-	c         Config
-	generated int
-	timeout   <-chan time.Time
-	endTime   time.Time
-	ctx       context.Context
-	out       func(string)
+	// claimed is the number of items that workers have started.  It is updated
+	// atomically so it is first in the struct to keep it 64-bit aligned on
+	// 32-bit platforms.
+	claimed int64
+
+	name string
+	id   int
+	c    Config
+	ctx  context.Context
+	cpu  *cpuShaper
+	rate *rateLimiter
+	p    *progress
+	out  func(string)
+	exit func(code int)
 }
 
 func (w *workload) startWork() {
-	w.logf("(ID %d) Workload starting", w.id)
-	if w.c.TimeToRun > 0 {
-		dur := time.Duration(w.c.TimeToRun) * time.Second
-		w.endTime = time.Now().Add(dur)
-		w.timeout = time.After(dur)
+	n := w.c.parallelism()
+	w.logf("(ID %d) Workload starting: %s, %d worker(s)", w.id, w.c.describeWork(), n)
+	if err := w.c.validate(); err != nil {
+		w.logf("(ID %d) Workload can't start: %v", w.id, err)
+		w.p.finish(StateFailed, err)
+		return
 	}
-
-	for !w.isDone() {
-		w.itemDone(generateKey())
+	if w.c.NumToGen > 0 && w.c.Completions > 0 {
+		w.logf("(ID %d) Generating %d of %d keys as index %d of %d", w.id, w.c.numToGen(), w.c.NumToGen, w.c.CompletionIndex, w.c.Completions)
+	}
+	w.updateBudget()
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			activeWorkers.Inc()
+			defer activeWorkers.Dec()
+
+			t := w.cpu.newThrottle()
+			for w.claim() {
+				r, err := doItem(w.ctx, w.c.kind(), w.c.itemParams(), ModeLocal)
+				if err != nil {
+					if w.ctx.Err() != nil {
+						// Canceled part way through the item.
+						break
+					}
+					w.p.itemFailed()
+				}
+				w.itemDone(worker, r)
+				t.worked(w.ctx, r.Duration)
+			}
+		}(i)
 	}
+	wg.Wait()
+
+	remainingItems.WithLabelValues(w.name).Set(0)
+	remainingSeconds.WithLabelValues(w.name).Set(0)
+	w.done(w.ctx.Err() != nil)
 }
 
-func (w *workload) isDone() bool {
-	select {
-	case <-w.ctx.Done():
-		w.done(true)
-		return true
-	case <-w.timeout:
-		w.done(false)
-		return true
-	default:
+// timeLeft returns how much of TimeToRun is left.  Time spent paused doesn't
+// count.
+func (w *workload) timeLeft() time.Duration {
+	return time.Duration(w.c.TimeToRun)*time.Second - w.p.runTime()
+}
+
+// updateBudget updates the metrics for how much work is left.
+func (w *workload) updateBudget() {
+	if w.c.NumToGen > 0 {
+		left := int64(w.c.numToGen()) - w.p.count()
+		if left < 0 {
+			left = 0
+		}
+		remainingItems.WithLabelValues(w.name).Set(float64(left))
+	} else {
+		remainingItems.WithLabelValues(w.name).Set(-1)
 	}
 
-	if w.c.NumToGen > 0 && w.generated >= w.c.NumToGen {
-		w.done(false)
-		return true
+	if w.c.TimeToRun > 0 {
+		left := w.timeLeft().Seconds()
+		if left < 0 {
+			left = 0
+		}
+		remainingSeconds.WithLabelValues(w.name).Set(left)
+	} else {
+		remainingSeconds.WithLabelValues(w.name).Set(-1)
+	}
+}
+
+// claim reserves the next item for a worker, waiting if the workload is
+// paused or rate limited.  It returns false if the workload has been canceled or there is no
+// budget left.
+func (w *workload) claim() bool {
+	if !w.p.wait(w.ctx) {
+		return false
 	}
-	return false
+	if w.c.TimeToRun > 0 && w.timeLeft() <= 0 {
+		return false
+	}
+	if w.c.NumToGen > 0 && atomic.AddInt64(&w.claimed, 1) > int64(w.c.numToGen()) {
+		return false
+	}
+	return w.rate.wait(w.ctx)
 }
 
 func (w *workload) done(canceled bool) {
 	w.logf("(ID %d) Workload exiting", w.id)
-	if !canceled && w.c.ExitOnComplete {
-		os.Exit(w.c.ExitCode)
+	if canceled {
+		return
+	}
+	w.p.finish(StateCompleted, nil)
+	if w.c.ExitOnComplete {
+		w.exit(w.c.ExitCode)
 	}
 }
 
-func (w *workload) itemDone(desc string) {
-	w.generated = w.generated + 1
+func (w *workload) itemDone(worker int, r *ItemResult) {
+	generated := w.p.itemDone(r)
+	w.updateBudget()
 
 	var count string
 	if w.c.NumToGen > 0 {
-		count = fmt.Sprintf(" %d/%d", w.generated, w.c.NumToGen)
+		count = fmt.Sprintf(" %d/%d", generated, w.c.numToGen())
 	} else {
-		count = fmt.Sprintf(" %d/Inf", w.generated)
+		count = fmt.Sprintf(" %d/Inf", generated)
 	}
 
 	timeleft := ""
-	if w.timeout != nil {
-		timeleft = " " + humanize.RelTime(time.Now(), w.endTime, "left", "overdue")
+	if w.c.TimeToRun > 0 {
+		now := time.Now()
+		timeleft = " " + humanize.RelTime(now, now.Add(w.timeLeft()), "left", "overdue")
 	}
 
+	desc := r.Desc
 	if len(desc) > 0 {
 		desc = ": " + desc
 	}
 
-	w.logf("(ID %d%s%s) Item done%s", w.id, count, timeleft, desc)
+	w.logf("(ID %d.%d%s%s) Item done%s", w.id, worker, count, timeleft, desc)
 }
 
 func (w *workload) log(s string) {
@@ -0,0 +1,259 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package memqbench is a load test for a MemQ server.  It runs a set of
+// producers and consumers against a single queue and reports throughput and
+// latency.
+package memqbench
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/kubernetes-up-and-running/kuard/pkg/memq/client"
+	"github.com/pkg/errors"
+)
+
+// emptyQueueBackoff is how long a worker waits after finding the queue empty
+// or getting an error.
+const emptyQueueBackoff = 10 * time.Millisecond
+
+// Config is the input parameters to the benchmark.
+type Config struct {
+	Server string
+
+	// If Queue is empty, a new queue is created for the run and deleted
+	// afterwards so that messages left over from earlier runs don't skew the
+	// results.
+	Queue string
+
+	Producers int
+	Consumers int
+
+	// The benchmark stops when either of these is reached.  Zero is
+	// interpreted as "infinity" but at least one must be set.  Messages is the
+	// total number of messages across all producers (and consumers).
+	Duration time.Duration
+	Messages int64
+
+	// MessageSize is the size, in bytes, of each message body.
+	MessageSize int
+
+	// CreateQueue creates the named Queue before starting if it doesn't
+	// exist.
+	CreateQueue bool
+}
+
+// Result is the outcome of a benchmark run.  Latencies are in milliseconds.
+type Result struct {
+	Kind      string  `json:"kind"`
+	Queue     string  `json:"queue"`
+	Producers int     `json:"producers"`
+	Consumers int     `json:"consumers"`
+	Duration  float64 `json:"durationSeconds"`
+
+	Enqueued      int64   `json:"enqueued"`
+	Dequeued      int64   `json:"dequeued"`
+	EnqueueErrors int64   `json:"enqueueErrors"`
+	DequeueErrors int64   `json:"dequeueErrors"`
+	EmptyPolls    int64   `json:"emptyPolls"`
+	EnqueueRate   float64 `json:"enqueueRate"`
+	DequeueRate   float64 `json:"dequeueRate"`
+
+	// EnqueueLatency is the round trip time of enqueue calls.  TimeInQueue is
+	// the time from message creation (on the server) to being dequeued by a
+	// consumer.  TimeInQueue is only meaningful if the server and consumer
+	// clocks are in sync.
+	EnqueueLatency Percentiles `json:"enqueueLatencyMs"`
+	TimeInQueue    Percentiles `json:"timeInQueueMs"`
+}
+
+type Percentiles struct {
+	Min  float64 `json:"min"`
+	Mean float64 `json:"mean"`
+	P50  float64 `json:"p50"`
+	P90  float64 `json:"p90"`
+	P99  float64 `json:"p99"`
+	Max  float64 `json:"max"`
+}
+
+// workerStats are kept per goroutine and merged at the end to avoid
+// contention.
+type workerStats struct {
+	count     int64
+	errors    int64
+	empty     int64
+	latencies histogram
+}
+
+// Run runs the benchmark until its limits are reached or ctx is done.
+func Run(ctx context.Context, c Config) (*Result, error) {
+	if c.Duration <= 0 && c.Messages <= 0 {
+		return nil, errors.New("one of duration or messages must be set")
+	}
+	if c.Producers < 0 || c.Consumers < 0 || c.Producers+c.Consumers == 0 {
+		return nil, errors.New("need at least one producer or consumer")
+	}
+
+	client := memqclient.Client{BaseServerURL: strings.TrimSuffix(c.Server, "/")}
+	if len(c.Queue) == 0 {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c.Queue = "bench-" + hex.EncodeToString(b)
+		if err := client.CreateQueue(c.Queue); err != nil {
+			return nil, errors.Wrapf(err, "creating queue %s", c.Queue)
+		}
+		defer func() {
+			if err := client.DeleteQueue(c.Queue); err != nil {
+				log.Printf("Error deleting queue %s: %v", c.Queue, err)
+			}
+		}()
+	} else if c.CreateQueue {
+		if err := client.EnsureQueue(c.Queue); err != nil {
+			return nil, errors.Wrapf(err, "creating queue %s", c.Queue)
+		}
+	}
+
+	if c.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Duration)
+		defer cancel()
+	}
+
+	body := strings.Repeat("x", c.MessageSize)
+	var sent, received int64
+
+	var wg sync.WaitGroup
+	producers := make([]*workerStats, c.Producers)
+	consumers := make([]*workerStats, c.Consumers)
+	start := time.Now()
+
+	for i := range producers {
+		s := &workerStats{}
+		producers[i] = s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if c.Messages > 0 && atomic.AddInt64(&sent, 1) > c.Messages {
+					return
+				}
+				t := time.Now()
+				_, err := client.Enqueue(c.Queue, body)
+				if err != nil {
+					// Give the message back so that it is retried.
+					if c.Messages > 0 {
+						atomic.AddInt64(&sent, -1)
+					}
+					s.errors++
+					time.Sleep(emptyQueueBackoff)
+					continue
+				}
+				s.latencies.add(time.Since(t))
+				s.count++
+			}
+		}()
+	}
+
+	for i := range consumers {
+		s := &workerStats{}
+		consumers[i] = s
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				if c.Messages > 0 && atomic.AddInt64(&received, 1) > c.Messages {
+					return
+				}
+				m, err := client.Dequeue(c.Queue)
+				if err != nil || m == nil {
+					// Give the message back so that another poll takes it.
+					if c.Messages > 0 {
+						atomic.AddInt64(&received, -1)
+					}
+					if err != nil {
+						s.errors++
+					} else {
+						s.empty++
+					}
+					time.Sleep(emptyQueueBackoff)
+					continue
+				}
+				s.latencies.add(time.Since(m.Created))
+				s.count++
+			}
+		}()
+	}
+
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	r := &Result{
+		Kind:      "benchResult",
+		Queue:     c.Queue,
+		Producers: c.Producers,
+		Consumers: c.Consumers,
+		Duration:  elapsed.Seconds(),
+	}
+	var enqueueLatencies, timeInQueue histogram
+	for _, s := range producers {
+		r.Enqueued += s.count
+		r.EnqueueErrors += s.errors
+		enqueueLatencies.merge(&s.latencies)
+	}
+	for _, s := range consumers {
+		r.Dequeued += s.count
+		r.DequeueErrors += s.errors
+		r.EmptyPolls += s.empty
+		timeInQueue.merge(&s.latencies)
+	}
+	r.EnqueueRate = float64(r.Enqueued) / elapsed.Seconds()
+	r.DequeueRate = float64(r.Dequeued) / elapsed.Seconds()
+	r.EnqueueLatency = enqueueLatencies.percentiles()
+	r.TimeInQueue = timeInQueue.percentiles()
+
+	return r, nil
+}
+
+// WriteText writes a human readable summary of the result.
+func (r *Result) WriteText(w io.Writer) {
+	fmt.Fprintf(w, "Queue:          %s\n", r.Queue)
+	fmt.Fprintf(w, "Workers:        %d producers, %d consumers\n", r.Producers, r.Consumers)
+	fmt.Fprintf(w, "Duration:       %.2fs\n", r.Duration)
+	fmt.Fprintf(w, "Enqueued:       %d (%.1f msg/s, %d errors)\n", r.Enqueued, r.EnqueueRate, r.EnqueueErrors)
+	fmt.Fprintf(w, "Dequeued:       %d (%.1f msg/s, %d errors, %d empty polls)\n", r.Dequeued, r.DequeueRate, r.DequeueErrors, r.EmptyPolls)
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "%-16s %9s %9s %9s %9s %9s %9s\n", "Latency (ms)", "min", "mean", "p50", "p90", "p99", "max")
+	for _, l := range []struct {
+		name string
+		p    Percentiles
+	}{
+		{"enqueue", r.EnqueueLatency},
+		{"time in queue", r.TimeInQueue},
+	} {
+		fmt.Fprintf(w, "%-16s %9.2f %9.2f %9.2f %9.2f %9.2f %9.2f\n", l.name, l.p.Min, l.p.Mean, l.p.P50, l.p.P90, l.p.P99, l.p.Max)
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memqbench
+
+import (
+	"math"
+	"time"
+)
+
+// Latencies are counted in buckets whose bounds grow by histGrowth, starting
+// at histMin, so memory use doesn't grow with the length of the run.
+// Percentiles are accurate to within the 2% width of a bucket.  The last
+// bucket holds everything over about 5 hours.
+const (
+	histMin     = time.Microsecond
+	histGrowth  = 1.02
+	histBuckets = 1200
+)
+
+// histogram summarizes a set of durations.
+type histogram struct {
+	counts   [histBuckets]int64
+	n        int64
+	sum      time.Duration
+	min, max time.Duration
+}
+
+func (h *histogram) add(d time.Duration) {
+	if h.n == 0 || d < h.min {
+		h.min = d
+	}
+	if h.n == 0 || d > h.max {
+		h.max = d
+	}
+	h.n++
+	h.sum += d
+	h.counts[histBucket(d)]++
+}
+
+func (h *histogram) merge(o *histogram) {
+	if o.n == 0 {
+		return
+	}
+	if h.n == 0 || o.min < h.min {
+		h.min = o.min
+	}
+	if h.n == 0 || o.max > h.max {
+		h.max = o.max
+	}
+	h.n += o.n
+	h.sum += o.sum
+	for i, c := range o.counts {
+		h.counts[i] += c
+	}
+}
+
+// histBucket returns the index of the smallest bucket whose upper bound is at
+// least d.
+func histBucket(d time.Duration) int {
+	if d <= histMin {
+		return 0
+	}
+	i := int(math.Ceil(math.Log(float64(d)/float64(histMin)) / math.Log(histGrowth)))
+	if i >= histBuckets {
+		return histBuckets - 1
+	}
+	return i
+}
+
+// quantile returns (the upper bound of the bucket holding) the p quantile.
+func (h *histogram) quantile(p float64) time.Duration {
+	rank := int64(p * float64(h.n-1))
+	var seen int64
+	for i, c := range h.counts {
+		seen += c
+		if seen > rank {
+			d := time.Duration(float64(histMin) * math.Pow(histGrowth, float64(i)))
+			if d < h.min {
+				return h.min
+			}
+			if d > h.max {
+				return h.max
+			}
+			return d
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) percentiles() Percentiles {
+	if h.n == 0 {
+		return Percentiles{}
+	}
+
+	ms := func(d time.Duration) float64 {
+		return float64(d) / float64(time.Millisecond)
+	}
+	return Percentiles{
+		Min:  ms(h.min),
+		Mean: ms(h.sum / time.Duration(h.n)),
+		P50:  ms(h.quantile(0.50)),
+		P90:  ms(h.quantile(0.90)),
+		P99:  ms(h.quantile(0.99)),
+		Max:  ms(h.max),
+	}
+}
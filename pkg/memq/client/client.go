@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"path"
 
 	"github.com/kubernetes-up-and-running/kuard/pkg/memq"
@@ -53,9 +54,24 @@ func (c *Client) CreateQueue(queue string) error {
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	return errorFromResponse(resp)
 }
 
+// EnsureQueue creates queue if it doesn't already exist.
+func (c *Client) EnsureQueue(queue string) error {
+	err := c.CreateQueue(queue)
+	if err == nil {
+		return nil
+	}
+	// The server answers an existing queue with the same status as any other
+	// bad request, so look for the queue before giving up.
+	if s, serr := c.Stats(queue); serr == nil && len(s.Queues) > 0 {
+		return nil
+	}
+	return err
+}
+
 func (c *Client) DeleteQueue(queue string) error {
 	req, err := http.NewRequest("DELETE", c.queueURL(queue), nil)
 	if err != nil {
@@ -65,6 +81,7 @@ func (c *Client) DeleteQueue(queue string) error {
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	return errorFromResponse(resp)
 }
 
@@ -77,6 +94,7 @@ func (c *Client) DrainQueue(queue string) error {
 	if err != nil {
 		return err
 	}
+	defer resp.Body.Close()
 	return errorFromResponse(resp)
 }
 
@@ -91,6 +109,7 @@ func (c *Client) Enqueue(queue, data string) (*memq.Message, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	err = errorFromResponse(resp)
 	if err != nil {
 		return nil, err
@@ -115,6 +134,7 @@ func (c *Client) Dequeue(queue string) (*memq.Message, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	err = errorFromResponse(resp)
 	if err != nil {
 		return nil, err
@@ -131,8 +151,158 @@ func (c *Client) Dequeue(queue string) (*memq.Message, error) {
 	return m, nil
 }
 
-func (c *Client) Stats() (*memq.Stats, error) {
-	req, err := http.NewRequest("GET", c.BaseServerURL+"/stats", nil)
+func (c *Client) ListMessages(queue string) ([]*memq.Message, error) {
+	req, err := http.NewRequest("GET", c.queueURL(queue, "messages"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	err = errorFromResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &memq.Messages{}
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	return m.Messages, nil
+}
+
+func (c *Client) DeleteMessage(queue, id string) error {
+	req, err := http.NewRequest("DELETE", c.queueURL(queue, "messages", id), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return errorFromResponse(resp)
+}
+
+func (c *Client) RequeueMessage(queue, id string) error {
+	req, err := http.NewRequest("POST", c.queueURL(queue, "messages", id, "requeue"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return errorFromResponse(resp)
+}
+
+// MoveMessages moves the messages with the given ids from queue to dest.  If
+// no ids are given then all messages are moved.  The number of messages moved
+// is returned.
+func (c *Client) MoveMessages(queue, dest string, ids ...string) (int, error) {
+	body, err := json.Marshal(&memq.MoveRequest{Destination: dest, IDs: ids})
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequest("POST", c.queueURL(queue, "move"), bytes.NewBuffer(body))
+	if err != nil {
+		return 0, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	err = errorFromResponse(resp)
+	if err != nil {
+		return 0, err
+	}
+
+	m := &memq.MoveResult{}
+	err = json.NewDecoder(resp.Body).Decode(&m)
+	if err != nil {
+		return 0, err
+	}
+	return m.Moved, nil
+}
+
+// Subscribe registers a webhook that the server will push messages on queue
+// to.
+func (c *Client) Subscribe(queue, url string, concurrency int) (*memq.Subscription, error) {
+	body, err := json.Marshal(&memq.Subscription{URL: url, Concurrency: concurrency})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", c.queueURL(queue, "subscriptions"), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	err = errorFromResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &memq.Subscription{}
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (c *Client) Unsubscribe(queue, id string) error {
+	req, err := http.NewRequest("DELETE", c.queueURL(queue, "subscriptions", id), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return errorFromResponse(resp)
+}
+
+func (c *Client) Subscriptions(queue string) ([]*memq.Subscription, error) {
+	req, err := http.NewRequest("GET", c.queueURL(queue, "subscriptions"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	err = errorFromResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &memq.Subscriptions{}
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	if err != nil {
+		return nil, err
+	}
+	return s.Subscriptions, nil
+}
+
+// Stats gets statistics for the named queues.  If no queues are named then
+// stats for all queues are returned.
+func (c *Client) Stats(queues ...string) (*memq.Stats, error) {
+	u := c.BaseServerURL + "/stats"
+	if len(queues) > 0 {
+		u += "?" + url.Values{"queue": queues}.Encode()
+	}
+	req, err := http.NewRequest("GET", u, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -140,6 +310,7 @@ func (c *Client) Stats() (*memq.Stats, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	err = errorFromResponse(resp)
 	if err != nil {
 		return nil, err
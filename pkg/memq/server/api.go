@@ -17,15 +17,19 @@ limitations under the License.
 package memqserver
 
 import (
+	"encoding/json"
 	"io/ioutil"
+	"log"
 	"net/http"
 
 	"github.com/julienschmidt/httprouter"
 	"github.com/kubernetes-up-and-running/kuard/pkg/apiutils"
+	"github.com/kubernetes-up-and-running/kuard/pkg/memq"
 )
 
 type Server struct {
 	broker *Broker
+	c      Config
 }
 
 func NewServer() *Server {
@@ -34,6 +38,17 @@ func NewServer() *Server {
 	}
 }
 
+// Run starts any non-HTTP listeners that are configured.  It returns
+// immediately.
+func (s *Server) Run() {
+	if len(s.c.RESPAddress) > 0 {
+		go func() {
+			log.Printf("Serving MemQ RESP on %v", s.c.RESPAddress)
+			log.Fatal(s.ListenAndServeRESP(s.c.RESPAddress))
+		}()
+	}
+}
+
 func (s *Server) AddRoutes(router *httprouter.Router, base string) {
 	router.GET(base+"/stats", s.GetStats)
 	router.PUT(base+"/queues/:queue", s.CreateQueue)
@@ -41,6 +56,13 @@ func (s *Server) AddRoutes(router *httprouter.Router, base string) {
 	router.POST(base+"/queues/:queue/drain", s.DrainQueue)
 	router.POST(base+"/queues/:queue/dequeue", s.Dequeue)
 	router.POST(base+"/queues/:queue/enqueue", s.Enqueue)
+	router.POST(base+"/queues/:queue/move", s.MoveMessages)
+	router.GET(base+"/queues/:queue/messages", s.ListMessages)
+	router.DELETE(base+"/queues/:queue/messages/:id", s.DeleteMessage)
+	router.POST(base+"/queues/:queue/messages/:id/requeue", s.RequeueMessage)
+	router.GET(base+"/queues/:queue/subscriptions", s.ListSubscriptions)
+	router.POST(base+"/queues/:queue/subscriptions", s.Subscribe)
+	router.DELETE(base+"/queues/:queue/subscriptions/:id", s.Unsubscribe)
 }
 
 func (s *Server) CreateQueue(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
@@ -120,7 +142,125 @@ func (s *Server) Dequeue(w http.ResponseWriter, r *http.Request, p httprouter.Pa
 	apiutils.ServeJSON(w, &m)
 }
 
+func (s *Server) ListMessages(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	qName := p.ByName("queue")
+	if len(qName) == 0 {
+		http.Error(w, ErrEmptyName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	messages, err := s.broker.ListMessages(qName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiutils.ServeJSON(w, &memq.Messages{Kind: "messages", Messages: messages})
+}
+
+func (s *Server) DeleteMessage(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	qName := p.ByName("queue")
+	if len(qName) == 0 {
+		http.Error(w, ErrEmptyName.Error(), http.StatusBadRequest)
+		return
+	}
+	err := s.broker.DeleteMessage(qName, p.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (s *Server) RequeueMessage(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	qName := p.ByName("queue")
+	if len(qName) == 0 {
+		http.Error(w, ErrEmptyName.Error(), http.StatusBadRequest)
+		return
+	}
+	err := s.broker.RequeueMessage(qName, p.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
+func (s *Server) MoveMessages(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	qName := p.ByName("queue")
+	if len(qName) == 0 {
+		http.Error(w, ErrEmptyName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := memq.MoveRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(req.Destination) == 0 {
+		http.Error(w, ErrEmptyName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	n, err := s.broker.MoveMessages(qName, req.Destination, req.IDs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiutils.ServeJSON(w, &memq.MoveResult{Kind: "moveResult", Moved: n})
+}
+
+func (s *Server) ListSubscriptions(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	qName := p.ByName("queue")
+	if len(qName) == 0 {
+		http.Error(w, ErrEmptyName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subs, err := s.broker.Subscriptions(qName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiutils.ServeJSON(w, &memq.Subscriptions{Kind: "subscriptions", Subscriptions: subs})
+}
+
+func (s *Server) Subscribe(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	qName := p.ByName("queue")
+	if len(qName) == 0 {
+		http.Error(w, ErrEmptyName.Error(), http.StatusBadRequest)
+		return
+	}
+
+	req := memq.Subscription{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub, err := s.broker.Subscribe(qName, req.URL, req.Concurrency)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	apiutils.ServeJSON(w, sub)
+}
+
+func (s *Server) Unsubscribe(w http.ResponseWriter, r *http.Request, p httprouter.Params) {
+	qName := p.ByName("queue")
+	if len(qName) == 0 {
+		http.Error(w, ErrEmptyName.Error(), http.StatusBadRequest)
+		return
+	}
+	err := s.broker.Unsubscribe(qName, p.ByName("id"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	}
+}
+
 func (s *Server) GetStats(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	stats := s.broker.Stats()
+	stats := s.broker.Stats(r.URL.Query()["queue"]...)
 	apiutils.ServeJSON(w, &stats)
 }
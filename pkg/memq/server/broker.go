@@ -17,9 +17,11 @@ limitations under the License.
 package memqserver
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
+	"sort"
 	"sync"
 	"time"
 
@@ -30,19 +32,39 @@ var ErrEmptyQueue = errors.New("empty queue")
 var ErrNotExist = errors.New("does not exist")
 var ErrAlreadyExist = errors.New("already exists")
 var ErrEmptyName = errors.New("empty name")
+var ErrMessageNotExist = errors.New("message does not exist")
+var ErrSameQueue = errors.New("source and destination are the same queue")
 
 type Queue struct {
 	Depth    int64
 	Enqueued int64
 	Dequeued int64
 	Drained  int64
+	Deleted  int64
+	MovedIn  int64
+	MovedOut int64
+	Requeued int64
 	Messages []*memq.Message
 	mu       *sync.RWMutex
+
+	enqueueRate rateCounter
+	dequeueRate rateCounter
+
+	// timeInQueue is the total time that all dequeued messages spent waiting.
+	timeInQueue time.Duration
+
+	DeliveryAttempts int64
+	DeliveryFailures int64
+	subs             map[string]*subscription
 }
 
 type Broker struct {
 	Queues map[string]*Queue
 	mu     *sync.RWMutex
+
+	// notifyCh is closed and replaced every time a message is added.
+	notifyCh chan struct{}
+	notifyMu sync.Mutex
 }
 
 func newStats() *memq.Stats {
@@ -70,13 +92,15 @@ func newQueue(name string) *Queue {
 		Depth:    0,
 		Messages: make([]*memq.Message, 0),
 		mu:       &sync.RWMutex{},
+		subs:     make(map[string]*subscription),
 	}
 }
 
 func NewBroker() *Broker {
 	return &Broker{
-		Queues: make(map[string]*Queue),
-		mu:     &sync.RWMutex{},
+		Queues:   make(map[string]*Queue),
+		mu:       &sync.RWMutex{},
+		notifyCh: make(chan struct{}),
 	}
 }
 
@@ -97,10 +121,15 @@ func (b *Broker) DeleteQueue(name string) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
-	if _, ok := b.Queues[name]; !ok {
+	q, ok := b.Queues[name]
+	if !ok {
 		return ErrNotExist
 	}
 	delete(b.Queues, name)
+
+	q.mu.Lock()
+	q.cancelSubscriptions()
+	q.mu.Unlock()
 	return nil
 }
 
@@ -137,63 +166,332 @@ func (b *Broker) getQueue(queue string) (*Queue, error) {
 }
 
 func (b *Broker) PutMessage(queue, body string) (*memq.Message, error) {
-	q, err := b.getQueue(queue)
+	messages, _, err := b.pushMessages(queue, []string{body}, false)
 	if err != nil {
 		return nil, err
 	}
+	return messages[0], nil
+}
 
-	message, err := newMessage(body)
+// pushMessages atomically adds a message for each body to the tail (or head)
+// of the queue.  When pushing to the head, each message is pushed in turn so
+// the last body ends up at the head of the queue.  The depth of the queue
+// after the push is returned.
+func (b *Broker) pushMessages(queue string, bodies []string, head bool) ([]*memq.Message, int64, error) {
+	q, err := b.getQueue(queue)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	messages := make([]*memq.Message, 0, len(bodies))
+	for _, body := range bodies {
+		message, err := newMessage(body)
+		if err != nil {
+			return nil, 0, err
+		}
+		messages = append(messages, message)
 	}
 
 	q.mu.Lock()
 	defer q.mu.Unlock()
-	q.Messages = append(q.Messages, message)
-	q.Depth++
-	q.Enqueued++
-	return message, nil
+	for _, message := range messages {
+		if head {
+			q.Messages = append([]*memq.Message{message}, q.Messages...)
+		} else {
+			q.Messages = append(q.Messages, message)
+		}
+		q.Depth++
+		q.Enqueued++
+		q.enqueueRate.add(message.Created, 1)
+	}
+	b.notify()
+	return messages, q.Depth, nil
 }
 
 func (b *Broker) GetMessage(queue string) (*memq.Message, error) {
+	m, _, err := b.popMessage(queue, false)
+	return m, err
+}
+
+// popMessage takes a message off of the head (or tail) of the queue.  It also
+// returns the time the message was dequeued at, as recorded in the stats.
+func (b *Broker) popMessage(queue string, tail bool) (*memq.Message, time.Time, error) {
 	q, err := b.getQueue(queue)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
 	q.mu.Lock()
 	defer q.mu.Unlock()
 	if len(q.Messages) < 1 {
-		return nil, ErrEmptyQueue
+		return nil, time.Time{}, ErrEmptyQueue
 	}
 	var m *memq.Message
-	m, q.Messages = q.Messages[0], q.Messages[1:]
+	if tail {
+		last := len(q.Messages) - 1
+		m, q.Messages = q.Messages[last], q.Messages[:last]
+	} else {
+		m, q.Messages = q.Messages[0], q.Messages[1:]
+	}
 	q.Depth--
 	q.Dequeued++
-	return m, nil
+
+	now := time.Now()
+	q.dequeueRate.add(now, 1)
+	q.timeInQueue += now.Sub(m.Created)
+	return m, now, nil
 }
 
-func (b *Broker) Stats() *memq.Stats {
+// waitMessage takes a message off of the head of the first non-empty queue,
+// blocking until one is available or ctx is done.  Queues that don't exist are
+// treated as empty.  The time the message was dequeued at is returned as with
+// popMessage.
+func (b *Broker) waitMessage(ctx context.Context, queues []string) (string, *memq.Message, time.Time, error) {
+	for {
+		// Grab the notification channel before looking so that we can't miss a
+		// message that is put between looking and waiting.
+		added := b.messageAdded()
+		for _, queue := range queues {
+			m, dequeued, err := b.popMessage(queue, false)
+			if err == nil {
+				return queue, m, dequeued, nil
+			}
+			if err != ErrEmptyQueue && err != ErrNotExist {
+				return "", nil, time.Time{}, err
+			}
+		}
+
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return "", nil, time.Time{}, ctx.Err()
+		}
+	}
+}
+
+// notify wakes up everyone waiting for a message to be added.
+func (b *Broker) notify() {
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+	close(b.notifyCh)
+	b.notifyCh = make(chan struct{})
+}
+
+// messageAdded returns a channel that is closed the next time any message is
+// added to any queue.
+func (b *Broker) messageAdded() <-chan struct{} {
+	b.notifyMu.Lock()
+	defer b.notifyMu.Unlock()
+	return b.notifyCh
+}
+
+// depth returns the number of messages in the queue.
+func (b *Broker) depth(queue string) (int64, error) {
+	q, err := b.getQueue(queue)
+	if err != nil {
+		return 0, err
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.Depth, nil
+}
+
+// ListMessages returns a snapshot of the messages currently in a queue, oldest
+// first.
+func (b *Broker) ListMessages(queue string) ([]*memq.Message, error) {
+	q, err := b.getQueue(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return append([]*memq.Message{}, q.Messages...), nil
+}
+
+// DeleteMessage removes a single message from a queue.
+func (b *Broker) DeleteMessage(queue, id string) error {
+	q, err := b.getQueue(queue)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.remove(id); !ok {
+		return ErrMessageNotExist
+	}
+	q.Depth--
+	q.Deleted++
+	return nil
+}
+
+// RequeueMessage moves a single message to the tail of its queue.
+func (b *Broker) RequeueMessage(queue, id string) error {
+	q, err := b.getQueue(queue)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	m, ok := q.remove(id)
+	if !ok {
+		return ErrMessageNotExist
+	}
+	q.Messages = append(q.Messages, m)
+	q.Requeued++
+	return nil
+}
+
+// MoveMessages moves messages from src to the tail of dst, preserving their
+// order.  If no ids are given then all messages are moved.
+// If any of the ids can't be found then nothing is moved.
+func (b *Broker) MoveMessages(src, dst string, ids []string) (int, error) {
+	if src == dst {
+		return 0, ErrSameQueue
+	}
+
+	// Hold the broker lock for the whole move so that neither queue can be
+	// drained or deleted out from under us.
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	from, ok := b.Queues[src]
+	if !ok {
+		return 0, ErrNotExist
+	}
+	to, ok := b.Queues[dst]
+	if !ok {
+		return 0, ErrNotExist
+	}
+
+	// Always lock queues in name order to avoid deadlocking against a move in
+	// the other direction.
+	if src < dst {
+		from.mu.Lock()
+		to.mu.Lock()
+	} else {
+		to.mu.Lock()
+		from.mu.Lock()
+	}
+	defer from.mu.Unlock()
+	defer to.mu.Unlock()
+
+	var moved []*memq.Message
+	if len(ids) == 0 {
+		moved = from.Messages
+		from.Messages = make([]*memq.Message, 0)
+	} else {
+		want := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			want[id] = true
+		}
+		keep := make([]*memq.Message, 0, len(from.Messages))
+		for _, m := range from.Messages {
+			if want[m.ID] {
+				moved = append(moved, m)
+				delete(want, m.ID)
+			} else {
+				keep = append(keep, m)
+			}
+		}
+		if len(want) > 0 {
+			return 0, ErrMessageNotExist
+		}
+		from.Messages = keep
+	}
+
+	n := int64(len(moved))
+	to.Messages = append(to.Messages, moved...)
+	from.Depth -= n
+	from.MovedOut += n
+	to.Depth += n
+	to.MovedIn += n
+	b.notify()
+	return len(moved), nil
+}
+
+// remove takes the message with the given id out of the queue.  Counters are
+// left for the caller to update.  The queue lock must be held.
+func (q *Queue) remove(id string) (*memq.Message, bool) {
+	for i, m := range q.Messages {
+		if m.ID == id {
+			q.Messages = append(q.Messages[:i], q.Messages[i+1:]...)
+			return m, true
+		}
+	}
+	return nil, false
+}
+
+// Stats returns statistics for the named queues, sorted by name.  If no
+// queues are named then all queues are returned.  Names that don't exist are
+// ignored.
+func (b *Broker) Stats(queues ...string) *memq.Stats {
 	s := newStats()
 
 	b.mu.RLock()
 	defer b.mu.RUnlock()
 
-	for name, q := range b.Queues {
-		q.mu.RLock()
-		stat := memq.Stat{
-			Name:     name,
-			Depth:    q.Depth,
-			Enqueued: q.Enqueued,
-			Dequeued: q.Dequeued,
-			Drained:  q.Drained,
+	names := append([]string{}, queues...)
+	if len(names) == 0 {
+		names = make([]string, 0, len(b.Queues))
+		for name := range b.Queues {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		q, ok := b.Queues[name]
+		if !ok {
+			continue
 		}
-		s.Queues = append(s.Queues, stat)
-		q.mu.RUnlock()
+		s.Queues = append(s.Queues, q.stat(name, now))
 	}
 	return s
 }
 
+func (q *Queue) stat(name string, now time.Time) memq.Stat {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	stat := memq.Stat{
+		Name:          name,
+		Depth:         q.Depth,
+		Enqueued:      q.Enqueued,
+		Dequeued:      q.Dequeued,
+		Drained:       q.Drained,
+		Deleted:       q.Deleted,
+		MovedIn:       q.MovedIn,
+		MovedOut:      q.MovedOut,
+		Requeued:      q.Requeued,
+		EnqueueRate1m: q.enqueueRate.rate(now, time.Minute),
+		EnqueueRate5m: q.enqueueRate.rate(now, 5*time.Minute),
+		DequeueRate1m: q.dequeueRate.rate(now, time.Minute),
+		DequeueRate5m: q.dequeueRate.rate(now, 5*time.Minute),
+
+		DeliveryAttempts: q.DeliveryAttempts,
+		DeliveryFailures: q.DeliveryFailures,
+	}
+	// Requeued and moved messages keep their creation time so the head of the
+	// queue isn't necessarily the oldest message.
+	for _, m := range q.Messages {
+		if age := now.Sub(m.Created).Seconds(); age > stat.OldestAge {
+			stat.OldestAge = age
+		}
+	}
+	if q.Dequeued > 0 {
+		stat.AvgTimeInQueue = q.timeInQueue.Seconds() / float64(q.Dequeued)
+	}
+	return stat
+}
+
 func uuid() (string, error) {
 	b := make([]byte, 16)
 	_, err := rand.Read(b)
@@ -0,0 +1,49 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memqserver
+
+import (
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Config is the configuration for the MemQ server.
+type Config struct {
+	// If set, serve a Redis protocol (RESP) compatible listener on this
+	// address.  See resp.go for the commands supported.
+	RESPAddress string `json:"respAddress" mapstructure:"resp-address"`
+}
+
+func (s *Server) BindConfig(v *viper.Viper, fs *pflag.FlagSet) {
+	v.Set("memq", map[string]interface{}{})
+	fs.String("memq-resp-address", "", "If set, serve MemQ queues over the Redis protocol (RESP) on this address. For example ':6379'.")
+
+	// Iterate through all flags and register with the passed in viper.  Only
+	// apply to those flags with our prefix but strip it out.
+	fs.VisitAll(func(f *pflag.Flag) {
+		name := strings.TrimPrefix(f.Name, "memq-")
+		if name != f.Name {
+			v.BindPFlag("memq."+name, f)
+		}
+	})
+}
+
+func (s *Server) LoadConfig(c Config) {
+	s.c = c
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memqserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/kubernetes-up-and-running/kuard/pkg/memq"
+)
+
+const (
+	maxSubscriptionConcurrency = 64
+	minDeliveryBackoff         = 500 * time.Millisecond
+	maxDeliveryBackoff         = 30 * time.Second
+	deliveryTimeout            = 30 * time.Second
+)
+
+var ErrBadURL = errors.New("url must be absolute http or https")
+
+var pushClient = &http.Client{Timeout: deliveryTimeout}
+
+type subscription struct {
+	memq.Subscription
+	cancel context.CancelFunc
+}
+
+// Subscribe registers a webhook for a queue.  Messages are taken off the queue
+// by concurrency workers and POSTed to rawURL until the subscription is
+// removed.
+func (b *Broker) Subscribe(queue, rawURL string, concurrency int) (*memq.Subscription, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, ErrBadURL
+	}
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > maxSubscriptionConcurrency {
+		concurrency = maxSubscriptionConcurrency
+	}
+
+	id, err := uuid()
+	if err != nil {
+		return nil, err
+	}
+
+	// Hold the broker lock while registering so that the queue can't be
+	// deleted before the subscription is seen by cancelSubscriptions.
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	q, ok := b.Queues[queue]
+	if !ok {
+		return nil, ErrNotExist
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := &subscription{
+		Subscription: memq.Subscription{
+			Kind:        "subscription",
+			ID:          id,
+			Queue:       queue,
+			URL:         u.String(),
+			Concurrency: concurrency,
+		},
+		cancel: cancel,
+	}
+
+	q.mu.Lock()
+	q.subs[id] = sub
+	s := sub.Subscription
+	q.mu.Unlock()
+
+	for i := 0; i < concurrency; i++ {
+		go b.runSubscriber(ctx, q, sub)
+	}
+	return &s, nil
+}
+
+// Unsubscribe stops pushing messages to a webhook.  Any messages that are
+// being retried are returned to the head of the queue.
+func (b *Broker) Unsubscribe(queue, id string) error {
+	q, err := b.getQueue(queue)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	sub, ok := q.subs[id]
+	if !ok {
+		return ErrNotExist
+	}
+	sub.cancel()
+	delete(q.subs, id)
+	return nil
+}
+
+// Subscriptions returns a snapshot of the webhooks registered for a queue,
+// sorted by ID.
+func (b *Broker) Subscriptions(queue string) ([]*memq.Subscription, error) {
+	q, err := b.getQueue(queue)
+	if err != nil {
+		return nil, err
+	}
+
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	subs := make([]*memq.Subscription, 0, len(q.subs))
+	for _, sub := range q.subs {
+		s := sub.Subscription
+		subs = append(subs, &s)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return subs, nil
+}
+
+// cancelSubscriptions stops all webhooks for a queue.  The queue lock must be
+// held.
+func (q *Queue) cancelSubscriptions() {
+	for id, sub := range q.subs {
+		sub.cancel()
+		delete(q.subs, id)
+	}
+}
+
+func (b *Broker) runSubscriber(ctx context.Context, q *Queue, sub *subscription) {
+	for {
+		_, m, dequeued, err := b.waitMessage(ctx, []string{sub.Queue})
+		if err != nil {
+			return
+		}
+		body, err := json.Marshal(m)
+		if err != nil {
+			// Retrying won't help, so drop the message and keep going.
+			log.Printf("MemQ: could not encode message %v, dropping it: %v", m.ID, err)
+			continue
+		}
+		if !b.deliver(ctx, q, sub, m, body) {
+			b.returnMessage(q, m, dequeued)
+			return
+		}
+	}
+}
+
+// deliver POSTs body, the encoded m, to the subscription, retrying with
+// exponential backoff until it succeeds or ctx is done.  It returns false if
+// the message was not delivered.
+func (b *Broker) deliver(ctx context.Context, q *Queue, sub *subscription, m *memq.Message, body []byte) bool {
+	backoff := minDeliveryBackoff
+	for {
+		err := post(ctx, sub.URL, body)
+		q.recordDelivery(sub, err)
+		if err == nil {
+			return true
+		}
+		log.Printf("MemQ: delivery of %v to %v failed: %v. Retrying after %v.", m.ID, sub.URL, err, backoff)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return false
+		}
+		backoff *= 2
+		if backoff > maxDeliveryBackoff {
+			backoff = maxDeliveryBackoff
+		}
+	}
+}
+
+func post(ctx context.Context, u string, body []byte) error {
+	req, err := http.NewRequest("POST", u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := pushClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("HTTP Error: " + resp.Status)
+	}
+	return nil
+}
+
+func (q *Queue) recordDelivery(sub *subscription, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.DeliveryAttempts++
+	sub.Attempts++
+	if err != nil {
+		q.DeliveryFailures++
+		sub.Failures++
+		sub.LastError = err.Error()
+	} else {
+		sub.Delivered++
+	}
+}
+
+// returnMessage puts a message that couldn't be delivered back on the head of
+// the queue and undoes the dequeue, including its stats.  dequeued is when the
+// message was taken off the queue, as returned by waitMessage.
+func (b *Broker) returnMessage(q *Queue, m *memq.Message, dequeued time.Time) {
+	q.mu.Lock()
+	q.Messages = append([]*memq.Message{m}, q.Messages...)
+	q.Depth++
+	q.Dequeued--
+	q.dequeueRate.add(dequeued, -1)
+	q.timeInQueue -= dequeued.Sub(m.Created)
+	if q.timeInQueue < 0 {
+		q.timeInQueue = 0
+	}
+	q.mu.Unlock()
+
+	b.notify()
+}
@@ -0,0 +1,80 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memqserver
+
+import "time"
+
+// maxRateWindow is the longest window, in seconds, that we can compute a rate
+// over.
+const maxRateWindow = 5 * 60
+
+// rateCounter counts events in one second buckets over a sliding window.  It
+// is not safe for concurrent use; callers are expected to hold the queue lock.
+type rateCounter struct {
+	buckets [maxRateWindow]int64
+
+	// last is the unix second of the most recently written bucket.
+	last int64
+}
+
+// add counts n events at now.  Events older than the window are dropped, so n
+// can be negative to take back events counted earlier.
+func (r *rateCounter) add(now time.Time, n int64) {
+	s := now.Unix()
+	if s <= r.last-maxRateWindow {
+		return
+	}
+	if s > r.last {
+		// Zero out any buckets that we skipped over since the last write.
+		gap := s - r.last
+		if gap > maxRateWindow {
+			gap = maxRateWindow
+		}
+		for i := int64(1); i <= gap; i++ {
+			r.buckets[(r.last+i)%maxRateWindow] = 0
+		}
+		r.last = s
+	}
+	r.buckets[s%maxRateWindow] += n
+}
+
+// rate returns the average number of events per second over the window ending
+// at now.  It does not modify the counter so it is safe to call with only a
+// read lock held.
+func (r *rateCounter) rate(now time.Time, window time.Duration) float64 {
+	w := int64(window / time.Second)
+	if w > maxRateWindow {
+		w = maxRateWindow
+	}
+	if w <= 0 {
+		return 0
+	}
+
+	s := now.Unix()
+	var sum int64
+	for i := int64(0); i < w; i++ {
+		sec := s - i
+		if sec > r.last {
+			continue
+		}
+		if sec <= r.last-maxRateWindow {
+			break
+		}
+		sum += r.buckets[sec%maxRateWindow]
+	}
+	return float64(sum) / float64(w)
+}
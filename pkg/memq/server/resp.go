@@ -0,0 +1,293 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memqserver
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// This file implements a small subset of the Redis protocol (RESP) so that
+// off the shelf Redis clients can use the broker queues as lists.  Each Redis
+// key is a queue.  The head of the list (the "left" end) is the next message to
+// be dequeued.
+//
+// Supported commands: PING, LPUSH, RPUSH, LPOP, RPOP, BLPOP, LLEN, DEL.
+
+// maxBulkLen limits the size of a single argument to guard against bad
+// clients.
+const maxBulkLen = 16 * 1024 * 1024
+
+var errProtocol = errors.New("Protocol error")
+
+// ListenAndServeRESP listens on the TCP address addr and serves RESP requests
+// until there is an error.
+func (s *Server) ListenAndServeRESP(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go s.serveRESPConn(conn)
+	}
+}
+
+func (s *Server) serveRESPConn(conn net.Conn) {
+	defer conn.Close()
+
+	// Read commands in a separate goroutine so that we notice the client going
+	// away while we are blocked in BLPOP.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cmds := make(chan []string)
+	go func() {
+		defer cancel()
+		defer close(cmds)
+		r := bufio.NewReader(conn)
+		for {
+			args, err := readRESPCommand(r)
+			if err != nil {
+				if err != io.EOF {
+					log.Printf("RESP %v: %v", conn.RemoteAddr(), err)
+				}
+				return
+			}
+			if len(args) == 0 {
+				continue
+			}
+			select {
+			case cmds <- args:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	w := bufio.NewWriter(conn)
+	for args := range cmds {
+		s.execRESP(ctx, w, args)
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readRESPCommand reads either an array of bulk strings or an inline command
+// terminated by a newline.
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+
+	// A null (*-1) or empty (*0) array is an empty command.  Don't size
+	// anything from n up front as the client controls it.
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > 1024*1024 {
+		return nil, errProtocol
+	}
+	var args []string
+	for i := 0; i < n; i++ {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, errProtocol
+		}
+		l, err := strconv.Atoi(line[1:])
+		if err != nil || l < 0 || l > maxBulkLen {
+			return nil, errProtocol
+		}
+		buf := make([]byte, l+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		if string(buf[l:]) != "\r\n" {
+			return nil, errProtocol
+		}
+		args = append(args, string(buf[:l]))
+	}
+	return args, nil
+}
+
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (s *Server) execRESP(ctx context.Context, w *bufio.Writer, args []string) {
+	cmd := strings.ToUpper(args[0])
+	args = args[1:]
+
+	switch cmd {
+	case "PING":
+		switch len(args) {
+		case 0:
+			w.WriteString("+PONG\r\n")
+		case 1:
+			writeRESPBulk(w, args[0])
+		default:
+			writeRESPArgError(w, cmd)
+		}
+
+	case "LPUSH", "RPUSH":
+		if len(args) < 2 {
+			writeRESPArgError(w, cmd)
+			return
+		}
+		depth, err := s.respPush(args[0], args[1:], cmd == "LPUSH")
+		if err != nil {
+			writeRESPError(w, err)
+			return
+		}
+		writeRESPInt(w, depth)
+
+	case "LPOP", "RPOP":
+		if len(args) != 1 {
+			writeRESPArgError(w, cmd)
+			return
+		}
+		m, _, err := s.broker.popMessage(args[0], cmd == "RPOP")
+		if err == ErrEmptyQueue || err == ErrNotExist {
+			w.WriteString("$-1\r\n")
+			return
+		} else if err != nil {
+			writeRESPError(w, err)
+			return
+		}
+		writeRESPBulk(w, m.Body)
+
+	case "BLPOP":
+		if len(args) < 2 {
+			writeRESPArgError(w, cmd)
+			return
+		}
+		timeout, err := strconv.ParseFloat(args[len(args)-1], 64)
+		if err != nil || timeout < 0 {
+			w.WriteString("-ERR timeout is not a float or out of range\r\n")
+			return
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout*float64(time.Second)))
+			defer cancel()
+		}
+		queue, m, _, err := s.broker.waitMessage(ctx, args[:len(args)-1])
+		if err == context.DeadlineExceeded || err == context.Canceled {
+			w.WriteString("*-1\r\n")
+			return
+		} else if err != nil {
+			writeRESPError(w, err)
+			return
+		}
+		w.WriteString("*2\r\n")
+		writeRESPBulk(w, queue)
+		writeRESPBulk(w, m.Body)
+
+	case "LLEN":
+		if len(args) != 1 {
+			writeRESPArgError(w, cmd)
+			return
+		}
+		depth, err := s.broker.depth(args[0])
+		if err != nil && err != ErrNotExist {
+			writeRESPError(w, err)
+			return
+		}
+		writeRESPInt(w, depth)
+
+	case "DEL":
+		if len(args) < 1 {
+			writeRESPArgError(w, cmd)
+			return
+		}
+		var deleted int64
+		for _, queue := range args {
+			if s.broker.DeleteQueue(queue) == nil {
+				deleted++
+			}
+		}
+		writeRESPInt(w, deleted)
+
+	default:
+		fmt.Fprintf(w, "-ERR unknown command '%s'\r\n", sanitizeRESP(cmd))
+	}
+}
+
+// respPush pushes onto a queue, creating it first if necessary as Redis lists
+// spring into existence on first use.
+func (s *Server) respPush(queue string, bodies []string, head bool) (int64, error) {
+	_, depth, err := s.broker.pushMessages(queue, bodies, head)
+	if err != ErrNotExist {
+		return depth, err
+	}
+	err = s.broker.CreateQueue(queue)
+	if err != nil && err != ErrAlreadyExist {
+		return 0, err
+	}
+	_, depth, err = s.broker.pushMessages(queue, bodies, head)
+	return depth, err
+}
+
+// sanitizeRESP replaces control characters so that s can be safely echoed
+// back in an error reply.
+func sanitizeRESP(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+func writeRESPBulk(w *bufio.Writer, s string) {
+	fmt.Fprintf(w, "$%d\r\n%s\r\n", len(s), s)
+}
+
+func writeRESPInt(w *bufio.Writer, i int64) {
+	fmt.Fprintf(w, ":%d\r\n", i)
+}
+
+func writeRESPError(w *bufio.Writer, err error) {
+	fmt.Fprintf(w, "-ERR %s\r\n", sanitizeRESP(err.Error()))
+}
+
+func writeRESPArgError(w *bufio.Writer, cmd string) {
+	fmt.Fprintf(w, "-ERR wrong number of arguments for '%s' command\r\n", strings.ToLower(cmd))
+}
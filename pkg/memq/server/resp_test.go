@@ -0,0 +1,226 @@
+/*
+Copyright 2017 The KUAR Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memqserver
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// respConn is a minimal RESP client talking to a server over a pipe.
+type respConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func newRESPConn(t *testing.T, s *Server) *respConn {
+	client, server := net.Pipe()
+	go s.serveRESPConn(server)
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	return &respConn{t: t, conn: client, r: bufio.NewReader(client)}
+}
+
+// send writes raw bytes to the server.
+func (c *respConn) send(raw string) {
+	c.t.Helper()
+	if _, err := io.WriteString(c.conn, raw); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+// do sends a command and returns the reply, flattened to a string.  Arrays
+// are written as "[a b]" and nulls as "nil".
+func (c *respConn) do(args ...string) string {
+	c.t.Helper()
+	reply, err := c.tryDo(args...)
+	if err != nil {
+		c.t.Fatalf("%v: %v", args, err)
+	}
+	return reply
+}
+
+// tryDo is do for use off of the test goroutine, where t.Fatalf can't be
+// called.
+func (c *respConn) tryDo(args ...string) (string, error) {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, a := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.conn, cmd); err != nil {
+		return "", fmt.Errorf("write: %v", err)
+	}
+	reply, err := c.reply()
+	if err != nil {
+		return "", fmt.Errorf("reading reply: %v", err)
+	}
+	return reply, nil
+}
+
+func (c *respConn) reply() (string, error) {
+	line, err := readRESPLine(c.r)
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 {
+		return "", errProtocol
+	}
+	switch line[0] {
+	case '+', '-', ':':
+		return line, nil
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", err
+		}
+		if n < 0 {
+			return "nil", nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return "", err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return "", err
+		}
+		if n < 0 {
+			return "nil", nil
+		}
+		items := []string{}
+		for i := 0; i < n; i++ {
+			item, err := c.reply()
+			if err != nil {
+				return "", err
+			}
+			items = append(items, item)
+		}
+		return "[" + strings.Join(items, " ") + "]", nil
+	}
+	return "", errProtocol
+}
+
+// closed checks that the server hung up.
+func (c *respConn) closed() {
+	c.t.Helper()
+	if reply, err := c.reply(); err != io.EOF {
+		c.t.Fatalf("expected the connection to be closed, got %q, %v", reply, err)
+	}
+}
+
+func expect(t *testing.T, got, want string) {
+	t.Helper()
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRESPCommands(t *testing.T) {
+	c := newRESPConn(t, NewServer())
+	defer c.conn.Close()
+
+	expect(t, c.do("PING"), "+PONG")
+	expect(t, c.do("ping", "hello"), "hello")
+
+	// The head of the list is the next message out.
+	expect(t, c.do("RPUSH", "q", "b", "c"), ":2")
+	expect(t, c.do("LPUSH", "q", "a"), ":3")
+	expect(t, c.do("LLEN", "q"), ":3")
+	expect(t, c.do("LPOP", "q"), "a")
+	expect(t, c.do("RPOP", "q"), "c")
+	expect(t, c.do("LPOP", "q"), "b")
+	expect(t, c.do("LPOP", "q"), "nil")
+	expect(t, c.do("RPOP", "missing"), "nil")
+	expect(t, c.do("LLEN", "missing"), ":0")
+
+	expect(t, c.do("RPUSH", "other", "x"), ":1")
+	expect(t, c.do("DEL", "q", "other", "missing"), ":2")
+	expect(t, c.do("LLEN", "other"), ":0")
+
+	expect(t, c.do("LPUSH", "q"), "-ERR wrong number of arguments for 'lpush' command")
+	expect(t, c.do("NOPE"), "-ERR unknown command 'NOPE'")
+}
+
+func TestRESPBLPOP(t *testing.T) {
+	s := NewServer()
+	c := newRESPConn(t, s)
+	defer c.conn.Close()
+
+	// Times out on an empty queue.
+	start := time.Now()
+	expect(t, c.do("BLPOP", "q", "0.1"), "nil")
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Errorf("BLPOP returned after %v, before the timeout", d)
+	}
+
+	// Returns right away if there is a message.
+	expect(t, c.do("RPUSH", "q", "now"), ":1")
+	expect(t, c.do("BLPOP", "empty", "q", "1"), "[q now]")
+
+	// Wakes up when another client pushes.
+	errc := make(chan error, 1)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		other := newRESPConn(t, s)
+		defer other.conn.Close()
+		_, err := other.tryDo("RPUSH", "q", "later")
+		errc <- err
+	}()
+	expect(t, c.do("BLPOP", "q", "0"), "[q later]")
+	if err := <-errc; err != nil {
+		t.Fatalf("pushing from another client: %v", err)
+	}
+}
+
+func TestRESPMalformed(t *testing.T) {
+	s := NewServer()
+
+	// Null and empty arrays are ignored.
+	c := newRESPConn(t, s)
+	c.send("*-1\r\n*0\r\n")
+	expect(t, c.do("PING"), "+PONG")
+	c.conn.Close()
+
+	// The rest are protocol errors and the server hangs up.
+	for _, raw := range []string{
+		"*-2\r\n",
+		"*x\r\n",
+		"*1\r\n$-1\r\n",
+		"*1\r\n$x\r\n",
+		"*1\r\n$99999999999\r\n",
+		"*1\r\nPING\r\n",
+		"*1\r\n$4\r\nPINGxx",
+	} {
+		c := newRESPConn(t, s)
+		c.send(raw)
+		c.closed()
+		c.conn.Close()
+	}
+
+	// The server is still up.
+	c = newRESPConn(t, s)
+	defer c.conn.Close()
+	expect(t, c.do("PING"), "+PONG")
+}
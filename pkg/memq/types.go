@@ -29,6 +29,27 @@ type Stat struct {
 	Enqueued int64  `json:"enqueued"`
 	Dequeued int64  `json:"dequeued"`
 	Drained  int64  `json:"drained"`
+	Deleted  int64  `json:"deleted"`
+	MovedIn  int64  `json:"movedIn"`
+	MovedOut int64  `json:"movedOut"`
+	Requeued int64  `json:"requeued"`
+
+	// Push delivery to webhook subscriptions.  Failures include both errors
+	// talking to the endpoint and non-2xx responses.
+	DeliveryAttempts int64 `json:"deliveryAttempts"`
+	DeliveryFailures int64 `json:"deliveryFailures"`
+
+	// Rates are in messages per second averaged over the trailing window.
+	EnqueueRate1m float64 `json:"enqueueRate1m"`
+	EnqueueRate5m float64 `json:"enqueueRate5m"`
+	DequeueRate1m float64 `json:"dequeueRate1m"`
+	DequeueRate5m float64 `json:"dequeueRate5m"`
+
+	// OldestAge is the age, in seconds, of the oldest message in the
+	// queue.  AvgTimeInQueue is the mean time, in seconds, that dequeued
+	// messages spent waiting in the queue.
+	OldestAge      float64 `json:"oldestMessageAgeSeconds"`
+	AvgTimeInQueue float64 `json:"avgTimeInQueueSeconds"`
 }
 
 type Message struct {
@@ -37,3 +58,41 @@ type Message struct {
 	Body    string    `json:"body"`
 	Created time.Time `json:"creationTimestamp"`
 }
+
+type Messages struct {
+	Kind     string     `json:"kind"`
+	Messages []*Message `json:"messages"`
+}
+
+// MoveRequest is the body of a request to move messages between queues.  If
+// IDs is empty then all messages are moved.
+type MoveRequest struct {
+	Destination string   `json:"destination"`
+	IDs         []string `json:"ids"`
+}
+
+type MoveResult struct {
+	Kind  string `json:"kind"`
+	Moved int    `json:"moved"`
+}
+
+// Subscription is a webhook that messages on a queue are pushed to.  Each
+// message is POSTed as JSON to URL.  Any 2xx response is success; anything
+// else is retried with backoff.
+type Subscription struct {
+	Kind        string `json:"kind"`
+	ID          string `json:"id"`
+	Queue       string `json:"queue"`
+	URL         string `json:"url"`
+	Concurrency int    `json:"concurrency"`
+
+	Attempts  int64  `json:"attempts"`
+	Failures  int64  `json:"failures"`
+	Delivered int64  `json:"delivered"`
+	LastError string `json:"lastError"`
+}
+
+type Subscriptions struct {
+	Kind          string          `json:"kind"`
+	Subscriptions []*Subscription `json:"subscriptions"`
+}